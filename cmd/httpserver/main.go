@@ -1,22 +1,54 @@
 package main
 
 import (
-	"io"
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/oliverTuesta/http-tcp/internal/cache"
+	"github.com/oliverTuesta/http-tcp/internal/cors"
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/proxy"
+	"github.com/oliverTuesta/http-tcp/internal/ratelimit"
 	"github.com/oliverTuesta/http-tcp/internal/request"
 	"github.com/oliverTuesta/http-tcp/internal/response"
 	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/oliverTuesta/http-tcp/internal/sse"
+	"github.com/oliverTuesta/http-tcp/internal/tracing"
 )
 
-const port = 42069
-
 func main() {
+	addr := flag.String("addr", ":42069", `address to listen on: "host:port", or "unix:/path/to.sock"`)
+	socketMode := flag.String("socket-mode", "", "file mode for a unix socket, e.g. 0660")
+	proxyMode := flag.Bool("proxy", false, "act as a forward proxy for CONNECT and absolute-form requests")
+	proxyAllow := flag.String("proxy-allow", "", "comma-separated destinations the proxy may reach (default: all)")
+	proxyDeny := flag.String("proxy-deny", "", "comma-separated destinations the proxy refuses")
+	proxyUser := flag.String("proxy-user", "", "require Proxy-Authorization basic auth with this user")
+	proxyPasswordFile := flag.String("proxy-password-file", "", "file holding the password for -proxy-user (default: $PROXY_PASSWORD)")
+	proxyIdle := flag.Duration("proxy-idle-timeout", 5*time.Minute, "close tunnels idle for this long")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long to let in-flight requests finish when stopping or restarting")
+	maxConns := flag.Int("max-conns", 0, "serve at most this many connections at once (0: no limit)")
+	rejectWhenFull := flag.Bool("reject-when-full", false, "answer 503 instead of waiting when -max-conns is reached")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "answer 503 to clients with this many connections open (0: no limit)")
+	rateLimit := flag.Float64("rate-limit", 0, "requests per second allowed per client IP (0: no limit)")
+	rateBurst := flag.Int("rate-burst", 10, "requests a client may make at once under -rate-limit")
+	metricsPath := flag.String("metrics", "", `route serving Prometheus metrics, e.g. "/metrics" (default: off)`)
+	traceFile := flag.String("trace-file", "", "append a JSON line per traced request to this file")
+	corsOrigins := flag.String("cors-origins", "", `comma-separated origins, patterns like "https://*.example.com" or "*", allowed cross-origin requests`)
+	corsCredentials := flag.Bool("cors-credentials", false, `let cross-origin requests under -cors-origins carry credentials (not with "*")`)
+	cacheSize := flag.Int("cache-size", 0, "cache cacheable GET responses in up to this many bytes (0: no cache)")
+	flag.Parse()
 
-	handler := server.Handler(func(w io.Writer, req *request.Request) *server.HandlerError {
+	handler := server.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
 		switch req.RequestLine.RequestTarget {
 		case "/yourproblem":
 			return &server.HandlerError{
@@ -26,20 +58,203 @@ func main() {
 			return &server.HandlerError{
 				StatusCode: response.StatusInternalServerError,
 			}
+		case "/events":
+			return streamClock(w, req)
+		case "/hints":
+			hints := headers.NewHeaders()
+			hints.Add("link", "</style.css>; rel=preload; as=style")
+			if err := w.WriteInformational(response.StatusEarlyHints, hints); err != nil {
+				return &server.HandlerError{StatusCode: response.StatusInternalServerError}
+			}
+			return nil
+		case "/upload":
+			if err := req.ReadBody(); err != nil {
+				return server.NewHandlerError(err)
+			}
+			log.Printf("received %d byte upload", len(req.Body))
+			return nil
 		default:
-			return nil  // Success - no error
+			return nil // Success - no error
 		}
 	})
 
-	server, err := server.Serve(port, handler)
+	if *cacheSize > 0 {
+		handler = cache.New(cache.Config{MaxBytes: *cacheSize}).Handler(handler)
+	}
+
+	if *proxyMode {
+		cfg := proxy.Config{
+			Allow:       splitList(*proxyAllow),
+			Deny:        splitList(*proxyDeny),
+			IdleTimeout: *proxyIdle,
+		}
+		if *proxyUser != "" {
+			password, err := proxyPassword(*proxyPasswordFile)
+			if err != nil {
+				log.Fatalf("Error reading proxy password: %v", err)
+			}
+			cfg.Credentials = map[string]string{*proxyUser: password}
+		}
+		handler = proxy.New(cfg).Handler(handler)
+	}
+
+	if *corsOrigins != "" {
+		c, err := cors.New(cors.Config{
+			AllowedOrigins:   splitList(*corsOrigins),
+			AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH"},
+			AllowedHeaders:   []string{"*"},
+			AllowCredentials: *corsCredentials,
+			MaxAge:           10 * time.Minute,
+		})
+		if err != nil {
+			log.Fatalf("Error configuring CORS: %v", err)
+		}
+		handler = c.Handler(handler)
+	}
+
+	if *rateLimit > 0 {
+		handler = ratelimit.New(ratelimit.Config{Rate: *rateLimit, Burst: *rateBurst}).Handler(handler)
+	}
+
+	if *traceFile != "" {
+		exporter, err := tracing.NewJSONLExporter(*traceFile)
+		if err != nil {
+			log.Fatalf("Error opening trace file: %v", err)
+		}
+		defer exporter.Close()
+		handler = tracing.New(tracing.Config{Exporter: exporter}).Handler(handler)
+	}
+
+	var opts []server.Option
+	if *metricsPath != "" {
+		opts = append(opts, server.WithMetrics(*metricsPath))
+	}
+	if *maxConns > 0 {
+		opts = append(opts, server.WithMaxConnections(*maxConns, *rejectWhenFull))
+	}
+	if *maxConnsPerIP > 0 {
+		opts = append(opts, server.WithMaxConnectionsPerIP(*maxConnsPerIP))
+	}
+
+	listener, err := listen(*addr, *socketMode)
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	srv := server.ServeListener(listener, handler, opts...)
+	log.Println("Server listening on", srv.Addr())
+	if err := notifyReady(); err != nil {
+		log.Println("Error notifying parent process:", err)
+	}
 
+	// a restart signal hands the listener to a fresh copy of this binary,
+	// then drains like SIGINT and SIGTERM do
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, restartSignals...)...)
+	for sig := range sigChan {
+		if slices.Contains(restartSignals, sig) {
+			if err := restart(srv); err != nil {
+				log.Println("Restart failed, still serving:", err)
+				continue
+			}
+			log.Println("New process is serving, draining connections")
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Println("Drain cut short:", err)
+	}
 	log.Println("Server gracefully stopped")
 }
+
+// listen prefers a socket passed by a restarting parent, then one passed
+// by systemd socket activation, over addr.
+func listen(addr, socketMode string) (net.Listener, error) {
+	if listener, err := inheritedListener(); err == nil {
+		return listener, nil
+	}
+	if listeners, err := server.SystemdListeners(); err == nil {
+		for _, extra := range listeners[1:] {
+			extra.Close()
+		}
+		return listeners[0], nil
+	}
+
+	path, isUnix := strings.CutPrefix(addr, "unix:")
+	if !isUnix {
+		return server.Listen(addr)
+	}
+
+	var mode os.FileMode
+	if socketMode != "" {
+		parsed, err := strconv.ParseUint(socketMode, 8, 32)
+		if err != nil {
+			return nil, err
+		}
+		mode = os.FileMode(parsed)
+	}
+	return server.ListenUnix(path, mode)
+}
+
+// proxyPassword reads the proxy password from file, or else from
+// $PROXY_PASSWORD, rather than a flag anyone can see in the process list.
+func proxyPassword(file string) (string, error) {
+	if file == "" {
+		password := os.Getenv("PROXY_PASSWORD")
+		if password == "" {
+			return "", fmt.Errorf("-proxy-user needs -proxy-password-file or $PROXY_PASSWORD")
+		}
+		return password, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// streamClock sends the time once a second as server-sent events, resuming
+// the id sequence from Last-Event-ID.
+func streamClock(w *response.Writer, req *request.Request) *server.HandlerError {
+	stream, err := sse.NewStream(w, req)
+	if err != nil {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError}
+	}
+
+	id, _ := strconv.Atoi(stream.LastEventID())
+	events := make(chan sse.Event)
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case now := <-ticker.C:
+				id++
+				select {
+				case events <- sse.Event{ID: strconv.Itoa(id), Event: "tick", Data: now.Format(time.RFC3339)}:
+				case <-req.Context().Done():
+					return
+				}
+			}
+		}
+	}()
+
+	if err := stream.Run(req, events, 15*time.Second); err != nil {
+		log.Println("event stream ended:", err)
+	}
+	return nil
+}
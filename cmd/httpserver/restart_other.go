@@ -0,0 +1,27 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+// Passing the listener to a child process needs unix descriptor
+// inheritance, so there is nothing to restart with here.
+var restartSignals []os.Signal
+
+func restart(srv *server.Server) error {
+	return fmt.Errorf("restarting is not supported on this platform")
+}
+
+func notifyReady() error {
+	return nil
+}
+
+func inheritedListener() (net.Listener, error) {
+	return nil, fmt.Errorf("no inherited listener on this platform")
+}
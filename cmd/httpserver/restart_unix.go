@@ -0,0 +1,35 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+// SIGHUP and SIGUSR2 restart the server without dropping connections.
+var restartSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
+
+func restart(srv *server.Server) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return srv.StartChild(cmd, 30*time.Second)
+}
+
+func notifyReady() error {
+	return server.NotifyReady()
+}
+
+func inheritedListener() (net.Listener, error) {
+	return server.InheritedListener()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/oliverTuesta/http-tcp/internal/websocket"
+)
+
+const port = 42069
+
+func main() {
+
+	cfg := websocket.Config{
+		Protocols:         []string{"echo"},
+		EnableCompression: true,
+	}
+
+	handler := server.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		conn, err := websocket.Upgrade(w, req, cfg)
+		if err != nil {
+			return &server.HandlerError{
+				StatusCode: response.StatusBadRequest,
+				Message:    err.Error(),
+			}
+		}
+		go echo(conn)
+		return nil
+	})
+
+	server, err := server.Serve(port, handler)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	defer server.Close()
+	log.Println("WebSocket echo server started on port", port)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Server gracefully stopped")
+}
+
+func echo(conn *websocket.Conn) {
+	defer conn.Close(websocket.CloseNormal, "")
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				log.Println("websocket error:", err)
+			}
+			return
+		}
+
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			log.Println("websocket write error:", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,648 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/precondition"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+type Config struct {
+	// MaxBytes bounds what the stored responses take up, bodies and field
+	// lines together. Default 64 MiB.
+	MaxBytes int
+	// MaxEntrySize is the largest response stored. Default 1 MiB.
+	MaxEntrySize int
+
+	// Now is the clock; tests swap it for a fake one.
+	Now func() time.Time
+}
+
+// Cache is a shared HTTP cache (RFC 9111) in front of a handler. It
+// stores complete GET responses with explicit freshness, evicting the
+// least recently used once over MaxBytes.
+type Cache struct {
+	cfg Config
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+	size    int
+	// the Vary fields last seen for each method and target
+	vary map[string]*varyIndex
+	// requests running next for a missing entry, and entries being
+	// revalidated in the background
+	flights      map[string]chan struct{}
+	revalidating map[string]bool
+}
+
+type varyIndex struct {
+	fields  []string
+	entries int
+}
+
+func New(cfg Config) *Cache {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 64 << 20
+	}
+	if cfg.MaxEntrySize <= 0 {
+		cfg.MaxEntrySize = 1 << 20
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Cache{
+		cfg:          cfg,
+		lru:          list.New(),
+		entries:      map[string]*list.Element{},
+		vary:         map[string]*varyIndex{},
+		flights:      map[string]chan struct{}{},
+		revalidating: map[string]bool{},
+	}
+}
+
+// entry is a stored response. It is never changed once stored;
+// revalidation stores a new one.
+type entry struct {
+	key     string
+	primary string
+	vary    []string
+
+	status response.StatusCode
+	// fields exclude Age and hop-by-hop fields
+	fields [][2]string
+	body   []byte
+
+	storedAt   time.Time
+	initialAge time.Duration
+	control    directives
+	validators precondition.Validators
+	size       int
+}
+
+// Handler answers GET requests from the cache where it can and stores
+// what next answers where it may. Concurrent misses for the same target
+// wait for the first to finish rather than all calling next.
+func (c *Cache) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if req.RequestLine.Method != "GET" {
+			handlerError := next(w, req)
+			if unsafeMethods[req.RequestLine.Method] {
+				c.invalidate(req, w.Status())
+			}
+			return handlerError
+		}
+		reqControl := parseCacheControl(fieldValue(req.Headers, "cache-control"))
+		if reqControl.has("no-store") {
+			return next(w, req)
+		}
+
+		primary := primaryKey(req)
+		e := c.lookup(req, primary)
+		if e == nil {
+			return c.fetch(w, req, primary, next)
+		}
+
+		now := c.cfg.Now()
+		age := e.age(now)
+		acceptable := !reqControl.has("no-cache")
+		if maxAge, ok := reqControl.seconds("max-age"); ok && age > maxAge {
+			acceptable = false
+		}
+		switch {
+		case acceptable && age < e.lifetime():
+			return c.serve(w, req, e, now)
+		case acceptable && age < e.lifetime()+e.staleWindow():
+			c.revalidateInBackground(req, e, next)
+			return c.serve(w, req, e, now)
+		}
+
+		refreshed, rec, handlerError := c.revalidate(conditionalRequest(req, e), e, next)
+		switch {
+		case refreshed != nil:
+			return c.serve(w, req, refreshed, c.cfg.Now())
+		case rec.Status == "":
+			return handlerError
+		}
+		writeResponse(w, rec.Status, rec.Fields, rec.Body, nil)
+		return nil
+	}
+}
+
+// fetch runs next for a request nothing is stored for, unless another
+// request for the same target already is, in which case it waits to be
+// served what that one stores.
+func (c *Cache) fetch(w *response.Writer, req *request.Request, primary string, next server.Handler) *server.HandlerError {
+	c.mu.Lock()
+	if done, ok := c.flights[primary]; ok {
+		c.mu.Unlock()
+		select {
+		case <-done:
+		case <-req.Context().Done():
+			return nil
+		}
+		if e := c.lookup(req, primary); e != nil {
+			now := c.cfg.Now()
+			if e.age(now) < e.lifetime() {
+				return c.serve(w, req, e, now)
+			}
+		}
+		// what was stored does not fit this request; fetch it alone
+		return c.record(w, req, next, func() {})
+	}
+	done := make(chan struct{})
+	c.flights[primary] = done
+	c.mu.Unlock()
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			c.mu.Lock()
+			delete(c.flights, primary)
+			c.mu.Unlock()
+			close(done)
+		})
+	}
+	defer release()
+	return c.record(w, req, next, release)
+}
+
+// record runs next on w and stores its response if it may. release runs
+// early once the headers show the response will not be stored, so a
+// long-lived stream does not hold up requests waiting on it.
+func (c *Cache) record(w *response.Writer, req *request.Request, next server.Handler, release func()) *server.HandlerError {
+	rec := w.Record(c.cfg.MaxEntrySize)
+	w.OnWriteHeaders(func() {
+		if !storable(req, rec.Status, rec.Fields) {
+			release()
+		}
+	})
+
+	handlerError := next(w, req)
+	if !w.Hijacked() && w.StatusWritten() {
+		c.store(req, rec, c.cfg.Now())
+	}
+	return handlerError
+}
+
+// revalidate asks next, with cond from conditionalRequest, whether e is
+// still current. A 304 refreshes e, which is returned. Any other response is
+// stored if it may be and returned as a recording; its Status is "" if
+// next wrote nothing and returned handlerError instead.
+func (c *Cache) revalidate(cond *request.Request, e *entry, next server.Handler) (*entry, *response.Recording, *server.HandlerError) {
+	w := response.NewDetachedWriter(io.Discard)
+	rec := w.Record(-1)
+	handlerError := next(w, cond)
+	now := c.cfg.Now()
+
+	if rec.Status == response.StatusNotModified {
+		refreshed := newEntry(e.key, e.primary, e.vary, e.status, mergeFields(e.fields, rec.Fields), e.body, now)
+		c.insert(refreshed)
+		return refreshed, nil, nil
+	}
+	if rec.Status != "" {
+		c.store(cond, rec, now)
+	}
+	return nil, rec, handlerError
+}
+
+// revalidateInBackground revalidates e without holding up req, once at a
+// time for each entry.
+func (c *Cache) revalidateInBackground(req *request.Request, e *entry, next server.Handler) {
+	c.mu.Lock()
+	if c.revalidating[e.key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[e.key] = true
+	c.mu.Unlock()
+
+	// the request outlives its connection from here
+	cond := conditionalRequest(req, e)
+	cond = cond.WithContext(context.WithoutCancel(cond.Context()))
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, e.key)
+			c.mu.Unlock()
+		}()
+		c.revalidate(cond, e, next)
+	}()
+}
+
+// conditionalRequest is req asking whether e's validators still hold, in
+// place of any preconditions the client sent. It copies what it takes from
+// req, which may be pooled and recycled while cond is still in use.
+func conditionalRequest(req *request.Request, e *entry) *request.Request {
+	cond := req.WithContext(req.Context())
+	cond.RequestLine.RequestTarget = strings.Clone(req.RequestLine.RequestTarget)
+	cond.RequestLine.HttpVersion = strings.Clone(req.RequestLine.HttpVersion)
+	cond.Headers = headers.NewHeaders()
+	for key, value := range req.Headers {
+		cond.Headers[strings.Clone(key)] = strings.Clone(value)
+	}
+	for _, name := range []string{"if-match", "if-none-match", "if-modified-since", "if-unmodified-since", "if-range", "range"} {
+		delete(cond.Headers, name)
+	}
+	if !e.validators.ETag.IsZero() {
+		cond.Headers.Add("if-none-match", e.validators.ETag.String())
+	}
+	if !e.validators.LastModified.IsZero() {
+		cond.Headers.Add("if-modified-since", precondition.FormatHTTPDate(e.validators.LastModified))
+	}
+	return cond
+}
+
+// fields a 304 carries for the stored response it stands in for
+var notModifiedFields = map[string]bool{
+	"cache-control":    true,
+	"content-location": true,
+	"date":             true,
+	"etag":             true,
+	"expires":          true,
+	"last-modified":    true,
+	"vary":             true,
+}
+
+// serve answers req from e, with a 304 or 412 if the client's own
+// preconditions call for one.
+func (c *Cache) serve(w *response.Writer, req *request.Request, e *entry, now time.Time) *server.HandlerError {
+	age := headers.NewHeaders()
+	age.Add("age", strconv.Itoa(int(e.age(now)/time.Second)))
+
+	switch precondition.Evaluate(req, e.validators).Status {
+	case response.StatusNotModified:
+		var fields [][2]string
+		for _, field := range e.fields {
+			if notModifiedFields[field[0]] {
+				fields = append(fields, field)
+			}
+		}
+		writeResponse(w, response.StatusNotModified, fields, nil, age)
+	case response.StatusPreconditionFailed:
+		writeResponse(w, response.StatusPreconditionFailed, nil, nil, response.GetDefaultHeaders(0))
+	default:
+		writeResponse(w, e.status, e.fields, e.body, age)
+	}
+	return nil
+}
+
+// writeResponse writes a stored or recorded response, with extra fields
+// on top. A name repeated in fields goes out as several field lines.
+func writeResponse(w *response.Writer, status response.StatusCode, fields [][2]string, body []byte, extra headers.Headers) error {
+	h := headers.NewHeaders()
+	for _, field := range fields {
+		if _, ok := h[field[0]]; !ok {
+			h[field[0]] = field[1]
+		} else if err := w.AddHeader(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	for key, value := range extra {
+		h[key] = value
+	}
+
+	if err := w.WriteStatusLine(status); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+	_, err := w.WriteBody(body)
+	return err
+}
+
+// responses to GET that may be stored, given explicit freshness
+var storableStatus = map[response.StatusCode]bool{
+	response.StatusOk:       true,
+	response.StatusNotFound: true,
+}
+
+// storable reports whether a shared cache may store a response to req
+// with this status and these fields.
+func storable(req *request.Request, status response.StatusCode, fields [][2]string) bool {
+	if !storableStatus[status] {
+		return false
+	}
+	reqControl := parseCacheControl(fieldValue(req.Headers, "cache-control"))
+	control := parseCacheControl(joinFields(fields, "cache-control"))
+	switch {
+	case reqControl.has("no-store"), control.has("no-store"), control.has("private"):
+		return false
+	case !control.has("max-age") && !control.has("s-maxage") && !control.has("no-cache"):
+		return false
+	}
+
+	// another user's credentials or cookies must not be handed out
+	if _, ok := req.Headers.Get("authorization"); ok &&
+		!control.has("public") && !control.has("s-maxage") && !control.has("must-revalidate") {
+		return false
+	}
+	if joinFields(fields, "set-cookie") != "" {
+		return false
+	}
+
+	for _, name := range varyFields(fields) {
+		if name == "*" {
+			return false
+		}
+	}
+	return true
+}
+
+// store keeps rec as the response to req if it may, and if it was
+// recorded whole.
+func (c *Cache) store(req *request.Request, rec *response.Recording, now time.Time) {
+	if rec.Truncated || !storable(req, rec.Status, rec.Fields) {
+		return
+	}
+	if joinFields(rec.Fields, "transfer-encoding") != "" {
+		return
+	}
+	length, err := strconv.Atoi(joinFields(rec.Fields, "content-length"))
+	if err != nil || length != len(rec.Body) {
+		return
+	}
+
+	primary := primaryKey(req)
+	vary := varyFields(rec.Fields)
+	e := newEntry(variantKey(primary, vary, req), primary, vary, rec.Status, rec.Fields, rec.Body, now)
+	if e.size <= c.cfg.MaxEntrySize {
+		c.insert(e)
+	}
+}
+
+// fields meaningful only for one connection, and Age, which the cache
+// works out afresh
+var unstoredFields = map[string]bool{
+	"age":               true,
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"te":                true,
+	"trailer":           true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+func newEntry(key, primary string, vary []string, status response.StatusCode, fields [][2]string, body []byte, now time.Time) *entry {
+	e := &entry{
+		key:      key,
+		primary:  primary,
+		vary:     vary,
+		status:   status,
+		body:     body,
+		storedAt: now,
+		control:  parseCacheControl(joinFields(fields, "cache-control")),
+		size:     len(key) + len(body),
+	}
+	if age, err := strconv.Atoi(joinFields(fields, "age")); err == nil && age > 0 {
+		e.initialAge = time.Duration(age) * time.Second
+	}
+	if tag, ok := precondition.ParseETag(joinFields(fields, "etag")); ok {
+		e.validators.ETag = tag
+	}
+	if date, err := precondition.ParseHTTPDate(joinFields(fields, "last-modified")); err == nil {
+		e.validators.LastModified = date
+	}
+
+	for _, field := range fields {
+		name := strings.ToLower(field[0])
+		if unstoredFields[name] {
+			continue
+		}
+		e.fields = append(e.fields, [2]string{name, field[1]})
+		e.size += len(name) + len(field[1])
+	}
+	return e
+}
+
+// mergeFields updates stored fields with those of a 304, which replace
+// any of the same name.
+func mergeFields(stored, updated [][2]string) [][2]string {
+	replaced := map[string]bool{}
+	for _, field := range updated {
+		name := strings.ToLower(field[0])
+		if name != "content-length" && !unstoredFields[name] {
+			replaced[name] = true
+		}
+	}
+
+	var merged [][2]string
+	for _, field := range stored {
+		if !replaced[field[0]] {
+			merged = append(merged, field)
+		}
+	}
+	for _, field := range updated {
+		if replaced[strings.ToLower(field[0])] {
+			merged = append(merged, field)
+		}
+	}
+	return merged
+}
+
+func (e *entry) age(now time.Time) time.Duration {
+	return max(0, e.initialAge+now.Sub(e.storedAt))
+}
+
+// lifetime is how long e is fresh for; no-cache makes it stale at once.
+func (e *entry) lifetime() time.Duration {
+	if e.control.has("no-cache") {
+		return 0
+	}
+	if lifetime, ok := e.control.seconds("s-maxage"); ok {
+		return lifetime
+	}
+	lifetime, _ := e.control.seconds("max-age")
+	return lifetime
+}
+
+// staleWindow is how long past its lifetime e may still be served while
+// it is revalidated.
+func (e *entry) staleWindow() time.Duration {
+	if e.control.has("no-cache") || e.control.has("must-revalidate") || e.control.has("proxy-revalidate") {
+		return 0
+	}
+	window, _ := e.control.seconds("stale-while-revalidate")
+	return window
+}
+
+// the methods whose success invalidates the stored responses for their
+// target (RFC 9111 section 4.4)
+var unsafeMethods = map[string]bool{"POST": true, "PUT": true, "PATCH": true, "DELETE": true}
+
+// invalidate drops what is stored for the target of an unsafe request
+// that succeeded, since it has likely changed.
+func (c *Cache) invalidate(req *request.Request, status response.StatusCode) {
+	if !strings.HasPrefix(string(status), "2") && !strings.HasPrefix(string(status), "3") {
+		return
+	}
+	target := "GET " + fieldValue(req.Headers, "host") + req.RequestLine.RequestTarget
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Front(); el != nil; {
+		following := el.Next()
+		if el.Value.(*entry).primary == target {
+			c.remove(el)
+		}
+		el = following
+	}
+}
+
+func (c *Cache) lookup(req *request.Request, primary string) *entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	index, ok := c.vary[primary]
+	if !ok {
+		return nil
+	}
+	el, ok := c.entries[variantKey(primary, index.fields, req)]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*entry)
+}
+
+func (c *Cache) insert(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[e.key]; ok {
+		c.remove(old)
+	}
+	index, ok := c.vary[e.primary]
+	if !ok {
+		index = &varyIndex{}
+		c.vary[e.primary] = index
+	}
+	// variants stored under an older Vary stop being found and age out
+	index.fields = e.vary
+	index.entries++
+
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.size += e.size
+	for c.size > c.cfg.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	if index := c.vary[e.primary]; index != nil {
+		if index.entries--; index.entries == 0 {
+			delete(c.vary, e.primary)
+		}
+	}
+}
+
+// Len is the number of stored responses.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Size is the number of bytes the stored responses take up.
+func (c *Cache) Size() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func primaryKey(req *request.Request) string {
+	return req.RequestLine.Method + " " + fieldValue(req.Headers, "host") + req.RequestLine.RequestTarget
+}
+
+// variantKey adds to primary the request's values for the fields the
+// response varies on.
+func variantKey(primary string, vary []string, req *request.Request) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range vary {
+		b.WriteString("\x00" + name + "=" + strings.TrimSpace(fieldValue(req.Headers, name)))
+	}
+	return b.String()
+}
+
+// varyFields are the lowercased names listed by any Vary fields, sorted.
+func varyFields(fields [][2]string) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, name := range strings.Split(joinFields(fields, "vary"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// joinFields combines the values of every field line named name.
+func joinFields(fields [][2]string, name string) string {
+	var values []string
+	for _, field := range fields {
+		if strings.EqualFold(field[0], name) {
+			values = append(values, field[1])
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+func fieldValue(h headers.Headers, name string) string {
+	value, _ := h.Get(name)
+	return value
+}
+
+// directives are Cache-Control directives by lowercased name.
+type directives map[string]string
+
+func parseCacheControl(value string) directives {
+	d := directives{}
+	for _, directive := range strings.Split(value, ",") {
+		name, arg, _ := strings.Cut(directive, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" {
+			d[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return d
+}
+
+func (d directives) has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// seconds reads a delta-seconds argument. A malformed one is ignored.
+func (d directives) seconds(name string) (time.Duration, bool) {
+	value, ok := d[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
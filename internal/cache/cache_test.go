@@ -0,0 +1,369 @@
+package cache
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/cors"
+	"github.com/oliverTuesta/http-tcp/internal/precondition"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// origin answers every request with body and cacheControl, counting the
+// calls, and honors If-None-Match against etag.
+type origin struct {
+	calls        atomic.Int32
+	cacheControl string
+	etag         string
+	body         string
+}
+
+func (o *origin) handle(w *response.Writer, req *request.Request) *server.HandlerError {
+	o.calls.Add(1)
+	v := precondition.Validators{}
+	if o.etag != "" {
+		v.ETag = precondition.StrongETag(o.etag)
+	}
+	h := precondition.Headers(v)
+	if o.cacheControl != "" {
+		h.Add("cache-control", o.cacheControl)
+	}
+	if _, handled, _ := precondition.Check(w, req, v); handled {
+		return nil
+	}
+
+	h.Add("content-length", strconv.Itoa(len(o.body)))
+	h.Add("content-type", "text/plain")
+	w.WriteStatusLine(response.StatusOk)
+	w.WriteHeaders(h)
+	w.WriteBody([]byte(o.body))
+	return nil
+}
+
+// do sends a request through handler and returns the response, with its
+// body, once the handler is done.
+func do(t *testing.T, handler server.Handler, method, target, fields string) (*http.Response, string) {
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: x\r\n" + fields + "\r\n"))
+	require.NoError(t, err)
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer serverSide.Close()
+		w := response.NewWriter(serverSide, nil)
+		handlerError := handler(w, req)
+		if !w.StatusWritten() {
+			statusCode := response.StatusOk
+			if handlerError != nil {
+				statusCode = handlerError.StatusCode
+			}
+			w.WriteStatusLine(statusCode)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		}
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), &http.Request{Method: method})
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	<-done
+	return resp, string(body)
+}
+
+func newCache(cfg Config) (*Cache, *clock) {
+	clk := &clock{now: time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)}
+	cfg.Now = clk.Now
+	return New(cfg), clk
+}
+
+func TestHitAndAge(t *testing.T) {
+	c, clk := newCache(Config{})
+	o := &origin{cacheControl: "max-age=60", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	resp, body := do(t, handler, "GET", "/a", "")
+	assert.Equal(t, "hello", body)
+	assert.Empty(t, resp.Header.Get("Age"))
+
+	clk.Advance(5 * time.Second)
+	resp, body = do(t, handler, "GET", "/a", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "5", resp.Header.Get("Age"))
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// another target, and another method, are not hits
+	do(t, handler, "GET", "/b", "")
+	do(t, handler, "POST", "/a", "Content-Length: 0\r\n")
+	assert.Equal(t, int32(3), o.calls.Load())
+}
+
+func TestNotStored(t *testing.T) {
+	for _, tc := range []struct {
+		cacheControl string
+		fields       string
+	}{
+		{"", ""},
+		{"no-store, max-age=60", ""},
+		{"private, max-age=60", ""},
+		{"max-age=60", "Cache-Control: no-store\r\n"},
+		{"max-age=60", "Authorization: Bearer x\r\n"},
+	} {
+		c, _ := newCache(Config{})
+		o := &origin{cacheControl: tc.cacheControl, body: "hello"}
+		handler := c.Handler(o.handle)
+		do(t, handler, "GET", "/", tc.fields)
+		do(t, handler, "GET", "/", tc.fields)
+		assert.Equal(t, int32(2), o.calls.Load(), "%q %q", tc.cacheControl, tc.fields)
+		assert.Zero(t, c.Len())
+	}
+
+	// shared caches may store authorized responses marked public
+	c, _ := newCache(Config{})
+	o := &origin{cacheControl: "public, max-age=60", body: "hello"}
+	do(t, c.Handler(o.handle), "GET", "/", "Authorization: Bearer x\r\n")
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestSharedMaxAge(t *testing.T) {
+	c, clk := newCache(Config{})
+	o := &origin{cacheControl: "max-age=600, s-maxage=10", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/", "")
+	clk.Advance(11 * time.Second)
+	do(t, handler, "GET", "/", "")
+	assert.Equal(t, int32(2), o.calls.Load())
+}
+
+func TestRevalidation(t *testing.T) {
+	c, clk := newCache(Config{})
+	o := &origin{cacheControl: "max-age=10", etag: "v1", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/", "")
+	clk.Advance(20 * time.Second)
+
+	// the origin answers the cache's conditional request with a 304, and
+	// the client gets the stored body
+	resp, body := do(t, handler, "GET", "/", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "0", resp.Header.Get("Age"))
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// and the entry is fresh again
+	do(t, handler, "GET", "/", "")
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// a changed resource replaces the entry
+	o.etag, o.body = "v2", "changed"
+	clk.Advance(20 * time.Second)
+	_, body = do(t, handler, "GET", "/", "")
+	assert.Equal(t, "changed", body)
+	_, body = do(t, handler, "GET", "/", "")
+	assert.Equal(t, "changed", body)
+	assert.Equal(t, int32(3), o.calls.Load())
+}
+
+func TestRequestNoCache(t *testing.T) {
+	c, _ := newCache(Config{})
+	o := &origin{cacheControl: "max-age=60", etag: "v1", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/", "")
+	_, body := do(t, handler, "GET", "/", "Cache-Control: no-cache\r\n")
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, int32(2), o.calls.Load())
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	c, clk := newCache(Config{})
+	o := &origin{cacheControl: "max-age=10, stale-while-revalidate=30", etag: "v1", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/", "")
+	clk.Advance(15 * time.Second)
+
+	resp, body := do(t, handler, "GET", "/", "")
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, "15", resp.Header.Get("Age"))
+	assert.Eventually(t, func() bool { return o.calls.Load() == 2 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		resp, _ := do(t, handler, "GET", "/", "")
+		return resp.Header.Get("Age") == "0"
+	}, time.Second, time.Millisecond)
+
+	// past the window the client waits for revalidation
+	clk.Advance(50 * time.Second)
+	resp, _ = do(t, handler, "GET", "/", "")
+	assert.Equal(t, "0", resp.Header.Get("Age"))
+	assert.Equal(t, int32(3), o.calls.Load())
+}
+
+func TestClientConditional(t *testing.T) {
+	c, _ := newCache(Config{})
+	o := &origin{cacheControl: "max-age=60", etag: "v1", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/", "")
+	resp, body := do(t, handler, "GET", "/", "If-None-Match: \"v1\"\r\n")
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Empty(t, body)
+	assert.Equal(t, `"v1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Equal(t, int32(1), o.calls.Load())
+}
+
+func TestVary(t *testing.T) {
+	c, _ := newCache(Config{})
+	var calls atomic.Int32
+	handler := c.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		calls.Add(1)
+		lang, _ := req.Headers.Get("accept-language")
+		h := response.GetDefaultHeaders(len(lang))
+		h.Add("cache-control", "max-age=60")
+		h.Add("vary", "Accept-Language")
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(lang))
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		_, body := do(t, handler, "GET", "/", "Accept-Language: en\r\n")
+		assert.Equal(t, "en", body)
+		_, body = do(t, handler, "GET", "/", "Accept-Language: es\r\n")
+		assert.Equal(t, "es", body)
+	}
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestEviction(t *testing.T) {
+	o := &origin{cacheControl: "max-age=60", body: strings.Repeat("x", 100)}
+	c, _ := newCache(Config{MaxBytes: 400})
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/a", "")
+	do(t, handler, "GET", "/b", "")
+	do(t, handler, "GET", "/a", "")
+	do(t, handler, "GET", "/c", "")
+	assert.Equal(t, 2, c.Len())
+	assert.LessOrEqual(t, c.Size(), 400)
+	assert.Equal(t, int32(3), o.calls.Load())
+
+	// /b was the least recently used
+	do(t, handler, "GET", "/a", "")
+	do(t, handler, "GET", "/c", "")
+	assert.Equal(t, int32(3), o.calls.Load())
+	do(t, handler, "GET", "/b", "")
+	assert.Equal(t, int32(4), o.calls.Load())
+
+	// too large to store at all
+	c, _ = newCache(Config{MaxEntrySize: 50})
+	do(t, c.Handler(o.handle), "GET", "/a", "")
+	assert.Zero(t, c.Len())
+}
+
+func TestCoalescing(t *testing.T) {
+	c, _ := newCache(Config{})
+	o := &origin{cacheControl: "max-age=60", body: "hello"}
+	release := make(chan struct{})
+	var arrived atomic.Int32
+	handler := c.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		<-release
+		return o.handle(w, req)
+	})
+	counted := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		arrived.Add(1)
+		return handler(w, req)
+	}
+
+	const clients = 10
+	var wg sync.WaitGroup
+	bodies := make([]string, clients)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, bodies[i] = do(t, counted, "GET", "/", "")
+		}()
+	}
+	require.Eventually(t, func() bool { return arrived.Load() == clients }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), o.calls.Load())
+	for _, body := range bodies {
+		assert.Equal(t, "hello", body)
+	}
+}
+
+func TestUnsafeMethodInvalidates(t *testing.T) {
+	c, _ := newCache(Config{})
+	o := &origin{cacheControl: "max-age=60", body: "hello"}
+	handler := c.Handler(o.handle)
+
+	do(t, handler, "GET", "/a", "")
+	do(t, handler, "GET", "/b", "")
+	// OPTIONS is safe and changes nothing
+	do(t, handler, "OPTIONS", "/a", "")
+	assert.Equal(t, 2, c.Len())
+	do(t, handler, "PUT", "/a", "Content-Length: 0\r\n")
+	assert.Equal(t, 1, c.Len())
+	do(t, handler, "DELETE", "/b", "")
+	assert.Equal(t, 0, c.Len())
+	do(t, handler, "GET", "/a", "")
+	assert.Equal(t, int32(6), o.calls.Load())
+}
+
+func TestBehindCORS(t *testing.T) {
+	c, _ := newCache(Config{})
+	o := &origin{cacheControl: "max-age=60", body: "hello"}
+	// the order main wires them in
+	cr, err := cors.New(cors.Config{AllowedOrigins: []string{"https://app.example.com"}})
+	require.NoError(t, err)
+	handler := cr.Handler(c.Handler(o.handle))
+
+	for i := 0; i < 2; i++ {
+		resp, body := do(t, handler, "GET", "/a", "Origin: https://app.example.com\r\n")
+		assert.Equal(t, "hello", body)
+		assert.Equal(t, []string{"https://app.example.com"}, resp.Header.Values("Access-Control-Allow-Origin"), "request %d", i)
+		assert.Equal(t, []string{"Origin"}, resp.Header.Values("Vary"), "request %d", i)
+	}
+	assert.Equal(t, int32(1), o.calls.Load())
+}
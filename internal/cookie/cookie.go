@@ -0,0 +1,219 @@
+package cookie
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+var ERROR_INVALID_COOKIE = fmt.Errorf("invalid cookie")
+var ERROR_INVALID_COOKIE_NAME = fmt.Errorf("invalid cookie name")
+var ERROR_INVALID_COOKIE_VALUE = fmt.Errorf("invalid cookie value")
+var ERROR_INVALID_COOKIE_ATTRIBUTE = fmt.Errorf("invalid cookie attribute")
+var ERROR_INSECURE_COOKIE = fmt.Errorf("SameSite=None and Partitioned cookies must be Secure")
+
+// expiresFormat is the IMF-fixdate format RFC 6265 asks for.
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Cookie is a name/value pair from a Cookie header.
+type Cookie struct {
+	Name  string
+	Value string
+}
+
+// Parse reads a Cookie header value. Pairs that break the RFC 6265
+// cookie-string grammar are skipped, and reported with
+// ERROR_INVALID_COOKIE next to the pairs that were valid.
+func Parse(value string) ([]Cookie, error) {
+	var cookies []Cookie
+	var err error
+	for _, pair := range strings.Split(value, ";") {
+		pair = strings.Trim(pair, " \t")
+		if pair == "" {
+			continue
+		}
+
+		name, val, ok := strings.Cut(pair, "=")
+		if !ok || !validName(name) || !validValue(val) {
+			err = ERROR_INVALID_COOKIE
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: unquote(val)})
+	}
+	return cookies, err
+}
+
+// FromRequest parses the request's Cookie header, as Parse does.
+func FromRequest(req *request.Request) ([]Cookie, error) {
+	value, ok := req.Headers.Get("cookie")
+	if !ok {
+		return nil, nil
+	}
+	return Parse(value)
+}
+
+// Get returns the value of the first valid cookie called name.
+func Get(req *request.Request, name string) (string, bool) {
+	cookies, _ := FromRequest(req)
+	for _, c := range cookies {
+		if c.Name == name {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+type SameSite string
+
+const (
+	SameSiteDefault SameSite = ""
+	SameSiteLax     SameSite = "Lax"
+	SameSiteStrict  SameSite = "Strict"
+	SameSiteNone    SameSite = "None"
+)
+
+// SetCookie is a cookie for the client to store, sent in a Set-Cookie
+// field.
+type SetCookie struct {
+	Name  string
+	Value string
+
+	// Expires is left out when zero. MaxAge is left out when zero; a
+	// negative MaxAge deletes the cookie by sending Max-Age=0.
+	Expires time.Time
+	MaxAge  int
+
+	Domain      string
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// String serializes c as a Set-Cookie field value.
+func (c SetCookie) String() (string, error) {
+	if !validName(c.Name) {
+		return "", ERROR_INVALID_COOKIE_NAME
+	}
+	if !validValue(c.Value) {
+		return "", ERROR_INVALID_COOKIE_VALUE
+	}
+	if !validAttributeValue(c.Domain) || !validAttributeValue(c.Path) {
+		return "", ERROR_INVALID_COOKIE_ATTRIBUTE
+	}
+	switch c.SameSite {
+	case SameSiteDefault, SameSiteLax, SameSiteStrict, SameSiteNone:
+	default:
+		return "", ERROR_INVALID_COOKIE_ATTRIBUTE
+	}
+	// browsers drop these cookies unless they are Secure
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return "", ERROR_INSECURE_COOKIE
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + c.Domain)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + string(c.SameSite))
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String(), nil
+}
+
+// Set adds c to the response as its own Set-Cookie line. It must be called
+// before the handler writes the headers.
+func Set(w *response.Writer, c SetCookie) error {
+	value, err := c.String()
+	if err != nil {
+		return err
+	}
+	return w.AddHeader("set-cookie", value)
+}
+
+// a cookie name is an RFC 9110 token
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if !isTchar(name[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTchar(c byte) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
+
+// a cookie value is cookie-octets, optionally wrapped in double quotes
+func validValue(value string) bool {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if !isCookieOctet(value[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// cookie-octet is printable US-ASCII without whitespace, DQUOTE, comma,
+// semicolon and backslash.
+func isCookieOctet(c byte) bool {
+	return c == 0x21 ||
+		(c >= 0x23 && c <= 0x2b) ||
+		(c >= 0x2d && c <= 0x3a) ||
+		(c >= 0x3c && c <= 0x5b) ||
+		(c >= 0x5d && c <= 0x7e)
+}
+
+// Domain and Path values may hold anything but controls and ';'
+func validAttributeValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if value[i] < 0x20 || value[i] == 0x7f || value[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,109 @@
+package cookie
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	cookies, err := Parse(`session=abc123; theme="dark";lang=en`)
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "lang", Value: "en"},
+	}, cookies)
+
+	// bad pairs are skipped but reported
+	cookies, err = Parse(`ok=1; no equals; bad name=2; bad=a b; quote=a"b; also=ok`)
+	assert.ErrorIs(t, err, ERROR_INVALID_COOKIE)
+	assert.Equal(t, []Cookie{{Name: "ok", Value: "1"}, {Name: "also", Value: "ok"}}, cookies)
+
+	cookies, err = Parse(`empty=`)
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{{Name: "empty", Value: ""}}, cookies)
+}
+
+func TestFromRequest(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader(
+		"GET / HTTP/1.1\r\nHost: x\r\nCookie: a=1; b=2\r\nCookie: c=3\r\n\r\n",
+	))
+	require.NoError(t, err)
+
+	cookies, err := FromRequest(req)
+	require.NoError(t, err)
+	assert.Len(t, cookies, 3)
+
+	value, ok := Get(req, "c")
+	assert.True(t, ok)
+	assert.Equal(t, "3", value)
+	_, ok = Get(req, "missing")
+	assert.False(t, ok)
+}
+
+func TestSetCookieString(t *testing.T) {
+	value, err := SetCookie{
+		Name:        "session",
+		Value:       "abc",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("x", 3600)),
+		MaxAge:      3600,
+		Domain:      "example.com",
+		Path:        "/",
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}.String()
+	require.NoError(t, err)
+	assert.Equal(t, "session=abc; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; Domain=example.com; Path=/; Secure; HttpOnly; SameSite=None; Partitioned", value)
+
+	value, err = SetCookie{Name: "session", MaxAge: -1}.String()
+	require.NoError(t, err)
+	assert.Equal(t, "session=; Max-Age=0", value)
+
+	for _, tc := range []struct {
+		cookie SetCookie
+		err    error
+	}{
+		{SetCookie{Name: "", Value: "x"}, ERROR_INVALID_COOKIE_NAME},
+		{SetCookie{Name: "a;b", Value: "x"}, ERROR_INVALID_COOKIE_NAME},
+		{SetCookie{Name: "a", Value: "x;y"}, ERROR_INVALID_COOKIE_VALUE},
+		{SetCookie{Name: "a", Value: "x\r\nEvil: 1"}, ERROR_INVALID_COOKIE_VALUE},
+		{SetCookie{Name: "a", Path: "/;Secure"}, ERROR_INVALID_COOKIE_ATTRIBUTE},
+		{SetCookie{Name: "a", SameSite: "Sometimes"}, ERROR_INVALID_COOKIE_ATTRIBUTE},
+		{SetCookie{Name: "a", SameSite: SameSiteNone}, ERROR_INSECURE_COOKIE},
+		{SetCookie{Name: "a", Partitioned: true}, ERROR_INSECURE_COOKIE},
+	} {
+		_, err := tc.cookie.String()
+		assert.ErrorIs(t, err, tc.err, tc.cookie.Name)
+	}
+}
+
+func TestSet(t *testing.T) {
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer serverSide.Close()
+		w := response.NewWriter(serverSide, nil)
+		Set(w, SetCookie{Name: "a", Value: "1", HttpOnly: true})
+		Set(w, SetCookie{Name: "b", Value: "2"})
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		assert.ErrorIs(t, Set(w, SetCookie{Name: "late"}), response.ERROR_WRITER_STATE)
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	assert.Equal(t, []string{"a=1; HttpOnly", "b=2"}, resp.Header.Values("Set-Cookie"))
+}
@@ -0,0 +1,208 @@
+package cors
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+var ERROR_WILDCARD_CREDENTIALS = fmt.Errorf(`AllowCredentials cannot be combined with the "*" origin`)
+
+type Config struct {
+	// AllowedOrigins lists the origins that may make cross-origin
+	// requests: exact ("https://app.example.com"), patterns with
+	// wildcards ("https://*.example.com") or "*" for any.
+	AllowedOrigins []string
+	// AllowedMethods are the methods preflights may ask for. Default GET
+	// and POST.
+	AllowedMethods []string
+	// AllowedHeaders are the request fields preflights may ask for,
+	// case-insensitively; "*" allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are the response fields scripts may read beyond the
+	// safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and HTTP
+	// authentication. It needs AllowedOrigins to list the origins: with
+	// "*" any site could make requests with the user's credentials and
+	// read the answers.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight. Zero leaves it to
+	// them.
+	MaxAge time.Duration
+	// AllowPrivateNetwork answers private network access preflights from
+	// public sites.
+	AllowPrivateNetwork bool
+}
+
+type CORS struct {
+	cfg            Config
+	anyOrigin      bool
+	anyHeader      bool
+	allowedHeaders map[string]bool
+}
+
+func New(cfg Config) (*CORS, error) {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{"GET", "POST"}
+	}
+	c := &CORS{cfg: cfg, allowedHeaders: map[string]bool{}}
+	for _, origin := range cfg.AllowedOrigins {
+		c.anyOrigin = c.anyOrigin || origin == "*"
+	}
+	if c.anyOrigin && cfg.AllowCredentials {
+		return nil, ERROR_WILDCARD_CREDENTIALS
+	}
+	for _, name := range cfg.AllowedHeaders {
+		c.anyHeader = c.anyHeader || name == "*"
+		c.allowedHeaders[strings.ToLower(name)] = true
+	}
+	return c, nil
+}
+
+// Allowed reports whether origin may make cross-origin requests.
+func (c *CORS) Allowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	for _, pattern := range c.cfg.AllowedOrigins {
+		if matched, err := path.Match(strings.ToLower(pattern), origin); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler answers CORS preflights itself and adds the CORS response
+// fields to cross-origin requests it passes on to next.
+func (c *CORS) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		origin, hasOrigin := req.Headers.Get("origin")
+		// whether the response depends on Origin, which caches must know
+		// about even for requests without one
+		if !c.staticOrigin() {
+			w.AddHeader("vary", "Origin")
+		}
+
+		_, preflight := req.Headers.Get("access-control-request-method")
+		if preflight && hasOrigin && req.RequestLine.Method == "OPTIONS" {
+			return c.preflight(w, req, origin)
+		}
+
+		if hasOrigin && c.Allowed(origin) {
+			w.AddHeader("access-control-allow-origin", c.allowOrigin(origin))
+			if c.cfg.AllowCredentials {
+				w.AddHeader("access-control-allow-credentials", "true")
+			}
+			if len(c.cfg.ExposedHeaders) > 0 {
+				w.AddHeader("access-control-expose-headers", strings.Join(c.cfg.ExposedHeaders, ", "))
+			}
+		}
+		return next(w, req)
+	}
+}
+
+// staticOrigin is set when every allowed origin is answered with "*",
+// which New only allows without credentials.
+func (c *CORS) staticOrigin() bool {
+	return c.anyOrigin
+}
+
+func (c *CORS) allowOrigin(origin string) string {
+	if c.staticOrigin() {
+		return "*"
+	}
+	return origin
+}
+
+// preflight answers an OPTIONS request asking whether the actual request
+// may be made: 204 with the permissions if so, 403 without them if not.
+func (c *CORS) preflight(w *response.Writer, req *request.Request, origin string) *server.HandlerError {
+	method, _ := req.Headers.Get("access-control-request-method")
+	requested, _ := req.Headers.Get("access-control-request-headers")
+	privateNetwork, _ := req.Headers.Get("access-control-request-private-network")
+	wantsPrivateNetwork := strings.EqualFold(strings.TrimSpace(privateNetwork), "true")
+
+	fieldNames := splitList(requested)
+	if !c.Allowed(origin) || !c.methodAllowed(strings.TrimSpace(method)) || !c.headersAllowed(fieldNames) ||
+		(wantsPrivateNetwork && !c.cfg.AllowPrivateNetwork) {
+		h := response.GetDefaultHeaders(0)
+		writeResponse(w, response.StatusForbidden, h)
+		return nil
+	}
+
+	h := headers.NewHeaders()
+	h.Add("access-control-allow-origin", c.allowOrigin(origin))
+	h.Add("access-control-allow-methods", strings.Join(c.cfg.AllowedMethods, ", "))
+	if len(fieldNames) > 0 {
+		// echo what was asked for, which unlike "*" also holds for
+		// credentialed requests
+		h.Add("access-control-allow-headers", strings.Join(fieldNames, ", "))
+	}
+	if c.cfg.AllowCredentials {
+		h.Add("access-control-allow-credentials", "true")
+	}
+	if c.cfg.MaxAge > 0 {
+		h.Add("access-control-max-age", strconv.Itoa(int(c.cfg.MaxAge/time.Second)))
+	}
+	if wantsPrivateNetwork {
+		h.Add("access-control-allow-private-network", "true")
+	}
+	w.AddHeader("vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+	writeResponse(w, response.StatusNoContent, h)
+	return nil
+}
+
+// the CORS-safelisted methods, which need no permission
+var safelistedMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true}
+
+func (c *CORS) methodAllowed(method string) bool {
+	if safelistedMethods[method] {
+		return true
+	}
+	for _, allowed := range c.cfg.AllowedMethods {
+		// methods are case-sensitive
+		if method == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) headersAllowed(names []string) bool {
+	if c.anyHeader {
+		return true
+	}
+	for _, name := range names {
+		if !c.allowedHeaders[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// splitList reads a comma-separated list of field names, lowercased.
+func splitList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func writeResponse(w *response.Writer, statusCode response.StatusCode, h headers.Headers) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	return w.WriteHeaders(h)
+}
@@ -0,0 +1,157 @@
+package cors
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCORS(t *testing.T, cfg Config) *CORS {
+	c, err := New(cfg)
+	require.NoError(t, err)
+	return c
+}
+
+// do sends a request through c in front of a handler answering 200, and
+// reports whether that handler ran.
+func do(t *testing.T, c *CORS, method, target, fields string) (*http.Response, bool) {
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: api.example.com\r\n" + fields + "\r\n"))
+	require.NoError(t, err)
+
+	called := false
+	handler := c.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		called = true
+		return nil
+	})
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer serverSide.Close()
+		w := response.NewWriter(serverSide, nil)
+		handler(w, req)
+		if !w.StatusWritten() {
+			w.WriteStatusLine(response.StatusOk)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		}
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	<-done
+	return resp, called
+}
+
+func TestAllowed(t *testing.T) {
+	c := newCORS(t, Config{AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:*"}})
+	for origin, want := range map[string]bool{
+		"https://app.example.com":  true,
+		"https://APP.example.com":  true,
+		"http://app.example.com":   false,
+		"https://a.example.org":    true,
+		"https://example.org":      false,
+		"https://a.example.org.io": false,
+		"http://localhost:3000":    true,
+		"http://localhost":         false,
+		"null":                     false,
+	} {
+		assert.Equal(t, want, c.Allowed(origin), origin)
+	}
+	assert.True(t, newCORS(t, Config{AllowedOrigins: []string{"*"}}).Allowed("null"))
+}
+
+func TestSimpleRequest(t *testing.T) {
+	c := newCORS(t, Config{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		ExposedHeaders:   []string{"X-Request-Id", "ETag"},
+		AllowCredentials: true,
+	})
+
+	resp, called := do(t, c, "GET", "/", "Origin: https://app.example.com\r\n")
+	assert.True(t, called)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "X-Request-Id, ETag", resp.Header.Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+
+	// other origins get no permissions, but the handler still runs
+	resp, called = do(t, c, "GET", "/", "Origin: https://evil.example\r\n")
+	assert.True(t, called)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+
+	// and so do same-origin requests, which still vary on Origin
+	resp, _ = do(t, c, "GET", "/", "")
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+}
+
+func TestWildcardOrigin(t *testing.T) {
+	resp, _ := do(t, newCORS(t, Config{AllowedOrigins: []string{"*"}}), "GET", "/", "Origin: https://a.example\r\n")
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, resp.Header.Get("Vary"))
+
+	// credentials for any origin would let every site act as the user
+	_, err := New(Config{AllowedOrigins: []string{"https://a.example", "*"}, AllowCredentials: true})
+	assert.ErrorIs(t, err, ERROR_WILDCARD_CREDENTIALS)
+}
+
+func TestPreflight(t *testing.T) {
+	c := newCORS(t, Config{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"GET", "PUT", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "X-Token"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	resp, called := do(t, c, "OPTIONS", "/items/1",
+		"Origin: https://app.example.com\r\nAccess-Control-Request-Method: PUT\r\nAccess-Control-Request-Headers: content-type, X-Token\r\n")
+	assert.False(t, called)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT, DELETE", resp.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, x-token", resp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+	assert.Contains(t, resp.Header.Values("Vary"), "Origin")
+
+	for name, fields := range map[string]string{
+		"origin":                     "Origin: https://evil.example\r\nAccess-Control-Request-Method: PUT\r\n",
+		"method":                     "Origin: https://app.example.com\r\nAccess-Control-Request-Method: PATCH\r\n",
+		"methods are case-sensitive": "Origin: https://app.example.com\r\nAccess-Control-Request-Method: put\r\n",
+		"header":                     "Origin: https://app.example.com\r\nAccess-Control-Request-Method: PUT\r\nAccess-Control-Request-Headers: X-Other\r\n",
+		"private":                    "Origin: https://app.example.com\r\nAccess-Control-Request-Method: GET\r\nAccess-Control-Request-Private-Network: true\r\n",
+	} {
+		resp, called := do(t, c, "OPTIONS", "/items/1", fields)
+		assert.False(t, called, name)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, name)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"), name)
+	}
+
+	// an OPTIONS request that is not a preflight is the handler's
+	_, called = do(t, c, "OPTIONS", "*", "")
+	assert.True(t, called)
+}
+
+func TestPrivateNetworkPreflight(t *testing.T) {
+	c := newCORS(t, Config{AllowedOrigins: []string{"https://app.example.com"}, AllowedHeaders: []string{"*"}, AllowPrivateNetwork: true})
+	resp, _ := do(t, c, "OPTIONS", "/",
+		"Origin: https://app.example.com\r\nAccess-Control-Request-Method: GET\r\nAccess-Control-Request-Headers: X-Anything\r\nAccess-Control-Request-Private-Network: true\r\n")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Private-Network"))
+	assert.Equal(t, "x-anything", resp.Header.Get("Access-Control-Allow-Headers"))
+}
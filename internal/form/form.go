@@ -0,0 +1,400 @@
+package form
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+var ERROR_MALFORMED_FORM = fmt.Errorf("malformed form body")
+var ERROR_FORM_TOO_LARGE error = limitError("form body larger than limit")
+var ERROR_TOO_MANY_PARTS error = limitError("form has too many parts")
+var ERROR_FIELD_TOO_LARGE error = limitError("form field larger than limit")
+var ERROR_MISSING_FIELD = fmt.Errorf("form field missing")
+var ERROR_BAD_FIELD = fmt.Errorf("form field has the wrong type")
+
+// Limits bound what a form may cost to parse. Zero fields take the
+// defaults.
+type Limits struct {
+	// MaxMemory is how many bytes of file parts are kept in memory; the
+	// rest are spilled to temporary files. Default 1 MiB.
+	MaxMemory int64
+	// MaxParts caps the number of fields and files. Default 1000.
+	MaxParts int
+	// MaxFieldSize caps each non-file value. Default 1 MiB.
+	MaxFieldSize int64
+	// MaxTotalSize caps the whole body. Default 32 MiB.
+	MaxTotalSize int64
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxMemory <= 0 {
+		l.MaxMemory = 1 << 20
+	}
+	if l.MaxParts <= 0 {
+		l.MaxParts = 1000
+	}
+	if l.MaxFieldSize <= 0 {
+		l.MaxFieldSize = 1 << 20
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = 32 << 20
+	}
+	return l
+}
+
+// Form is the fields of a request body merged with its query string,
+// body values first, and any uploaded files.
+type Form struct {
+	Values url.Values
+	Files  map[string][]*File
+}
+
+// File is an uploaded file part, held in memory or in a temporary file.
+type File struct {
+	Name        string
+	Filename    string
+	ContentType string
+	Size        int64
+
+	content []byte
+	path    string
+}
+
+// Open returns the file's content.
+func (f *File) Open() (io.ReadSeekCloser, error) {
+	if f.path == "" {
+		return nopCloser{bytes.NewReader(f.content)}, nil
+	}
+	return os.Open(f.path)
+}
+
+// OnDisk reports whether the file was spilled to a temporary file.
+func (f *File) OnDisk() bool {
+	return f.path != ""
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Parse reads the form in req: its query string, plus an
+// application/x-www-form-urlencoded or multipart/form-data body. Other
+// bodies are left alone. A body still on the connection is read from it
+// within MaxTotalSize, not into req.Body first. The caller must call
+// RemoveAll once done with the files.
+func Parse(req *request.Request, limits Limits) (*Form, error) {
+	limits = limits.withDefaults()
+	form := &Form{Values: url.Values{}, Files: map[string][]*File{}}
+
+	mediaType, _, err := req.ContentType()
+	switch {
+	case errors.Is(err, request.ERROR_MISSING_CONTENT_TYPE):
+	case err != nil:
+		return nil, err
+	case mediaType == "application/x-www-form-urlencoded":
+		body, err := req.BodyReader()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(&limitedReader{r: body, remaining: limits.MaxTotalSize})
+		if err != nil {
+			return nil, err
+		}
+		if err := form.parseURLEncoded(data, limits); err != nil {
+			return nil, err
+		}
+	case mediaType == "multipart/form-data":
+		boundary, err := req.MultipartBoundary()
+		if err != nil {
+			return nil, err
+		}
+		body, err := req.BodyReader()
+		if err != nil {
+			return nil, err
+		}
+		if err := form.parseMultipart(body, boundary, limits); err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+	}
+
+	if _, query, ok := strings.Cut(req.RequestLine.RequestTarget, "?"); ok {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			form.RemoveAll()
+			return nil, ERROR_MALFORMED_FORM
+		}
+		for key, vals := range values {
+			form.Values[key] = append(form.Values[key], vals...)
+		}
+	}
+	return form, nil
+}
+
+// ParseMultipart reads a multipart/form-data body from r as it arrives.
+func ParseMultipart(r io.Reader, boundary string, limits Limits) (*Form, error) {
+	form := &Form{Values: url.Values{}, Files: map[string][]*File{}}
+	if err := form.parseMultipart(r, boundary, limits.withDefaults()); err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (f *Form) parseURLEncoded(body []byte, limits Limits) error {
+	parts := 0
+	for _, pair := range strings.Split(string(body), "&") {
+		if pair == "" {
+			continue
+		}
+		if parts++; parts > limits.MaxParts {
+			return ERROR_TOO_MANY_PARTS
+		}
+
+		key, value, _ := strings.Cut(pair, "=")
+		key, err1 := url.QueryUnescape(key)
+		value, err2 := url.QueryUnescape(value)
+		if err1 != nil || err2 != nil {
+			return ERROR_MALFORMED_FORM
+		}
+		if int64(len(value)) > limits.MaxFieldSize {
+			return ERROR_FIELD_TOO_LARGE
+		}
+		f.Values.Add(key, value)
+	}
+	return nil
+}
+
+func (f *Form) parseMultipart(r io.Reader, boundary string, limits Limits) error {
+	body := &limitedReader{r: r, remaining: limits.MaxTotalSize}
+	mr := multipart.NewReader(body, boundary)
+	memory := limits.MaxMemory
+
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			// finish the body, so the connection can carry another request
+			if _, err := io.Copy(io.Discard, body); err != nil {
+				return multipartError(err)
+			}
+			return nil
+		}
+		if err != nil {
+			return multipartError(err)
+		}
+		if parts >= limits.MaxParts {
+			part.Close()
+			return ERROR_TOO_MANY_PARTS
+		}
+
+		name := part.FormName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, limits.MaxFieldSize+1))
+			part.Close()
+			if err != nil {
+				return multipartError(err)
+			}
+			if int64(len(value)) > limits.MaxFieldSize {
+				return ERROR_FIELD_TOO_LARGE
+			}
+			f.Values.Add(name, string(value))
+			continue
+		}
+
+		file, err := readFile(part, &memory)
+		part.Close()
+		if err != nil {
+			return err
+		}
+		file.Name = name
+		f.Files[name] = append(f.Files[name], file)
+	}
+}
+
+// readFile keeps a file part in memory while it fits in what is left of
+// the memory budget and spills it to a temporary file once it does not.
+func readFile(part *multipart.Part, memory *int64) (*File, error) {
+	file := &File{Filename: part.FileName(), ContentType: part.Header.Get("Content-Type")}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(part, *memory+1))
+	if err != nil {
+		return nil, multipartError(err)
+	}
+	if n <= *memory {
+		*memory -= n
+		file.content = buf.Bytes()
+		file.Size = n
+		return file, nil
+	}
+
+	tmp, err := os.CreateTemp("", "form-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	file.path = tmp.Name()
+	size, err := io.Copy(tmp, io.MultiReader(&buf, part))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.path)
+		return nil, multipartError(err)
+	}
+	file.Size = size
+	return file, nil
+}
+
+// limitError is a form going over one of its Limits, which the server
+// answers with 413.
+type limitError string
+
+func (e limitError) Error() string {
+	return string(e)
+}
+
+func (e limitError) StatusCode() response.StatusCode {
+	return response.StatusContentTooLarge
+}
+
+func multipartError(err error) error {
+	if errors.Is(err, ERROR_FORM_TOO_LARGE) {
+		return ERROR_FORM_TOO_LARGE
+	}
+	// reading the request itself failed
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ERROR_MALFORMED_FORM, err)
+}
+
+// RemoveAll deletes the temporary files behind spilled uploads.
+func (f *Form) RemoveAll() error {
+	var firstErr error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.path == "" {
+				continue
+			}
+			if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Has reports whether key was sent at all.
+func (f *Form) Has(key string) bool {
+	return f.Values.Has(key)
+}
+
+// Get returns the first value for key, or "".
+func (f *Form) Get(key string) string {
+	return f.Values.Get(key)
+}
+
+// All returns every value sent for key.
+func (f *Form) All(key string) []string {
+	return f.Values[key]
+}
+
+func (f *Form) String(key string) (string, error) {
+	if !f.Has(key) {
+		return "", fmt.Errorf("%w: %s", ERROR_MISSING_FIELD, key)
+	}
+	return f.Get(key), nil
+}
+
+func (f *Form) Int(key string) (int, error) {
+	value, err := f.String(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s is not an integer", ERROR_BAD_FIELD, key)
+	}
+	return n, nil
+}
+
+func (f *Form) Float(key string) (float64, error) {
+	value, err := f.String(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s is not a number", ERROR_BAD_FIELD, key)
+	}
+	return n, nil
+}
+
+// Bool reads "true"/"false" style values, and "on", the value browsers send
+// for a checked checkbox. A missing key is false, as for an unchecked one.
+func (f *Form) Bool(key string) (bool, error) {
+	if !f.Has(key) {
+		return false, nil
+	}
+	value := strings.ToLower(strings.TrimSpace(f.Get(key)))
+	if value == "on" || value == "yes" {
+		return true, nil
+	}
+	if value == "off" || value == "no" || value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s is not a boolean", ERROR_BAD_FIELD, key)
+	}
+	return b, nil
+}
+
+// File returns the first file uploaded as key.
+func (f *Form) File(key string) (*File, bool) {
+	files := f.Files[key]
+	if len(files) == 0 {
+		return nil, false
+	}
+	return files[0], true
+}
+
+// limitedReader fails with ERROR_FORM_TOO_LARGE once more than remaining
+// bytes have been read.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ERROR_FORM_TOO_LARGE
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ERROR_FORM_TOO_LARGE
+	}
+	return n, err
+}
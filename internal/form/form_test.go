@@ -0,0 +1,233 @@
+package form
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, target, contentType string, body []byte) *request.Request {
+	raw := "POST " + target + " HTTP/1.1\r\nHost: x\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+	req, err := request.RequestFromReader(io.MultiReader(strings.NewReader(raw+"\r\n"), bytes.NewReader(body)))
+	require.NoError(t, err)
+	return req
+}
+
+// multipartBody builds a multipart/form-data body from fields and files
+// (name, filename, content).
+func multipartBody(t *testing.T, fields [][2]string, files [][3]string) (string, []byte) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, field := range fields {
+		require.NoError(t, mw.WriteField(field[0], field[1]))
+	}
+	for _, file := range files {
+		w, err := mw.CreateFormFile(file[0], file[1])
+		require.NoError(t, err)
+		w.Write([]byte(file[2]))
+	}
+	require.NoError(t, mw.Close())
+	return mw.FormDataContentType(), body.Bytes()
+}
+
+func TestURLEncoded(t *testing.T) {
+	req := newRequest(t, "/search?page=2&tag=go", "application/x-www-form-urlencoded",
+		[]byte("q=hello+world&tag=http&age=42&price=9.5&subscribe=on&name=%C3%A9"))
+
+	form, err := Parse(req, Limits{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello world", form.Get("q"))
+	assert.Equal(t, "é", form.Get("name"))
+	// body values come before query values
+	assert.Equal(t, []string{"http", "go"}, form.All("tag"))
+
+	page, err := form.Int("page")
+	require.NoError(t, err)
+	assert.Equal(t, 2, page)
+	age, err := form.Int("age")
+	require.NoError(t, err)
+	assert.Equal(t, 42, age)
+	price, err := form.Float("price")
+	require.NoError(t, err)
+	assert.Equal(t, 9.5, price)
+	subscribe, err := form.Bool("subscribe")
+	require.NoError(t, err)
+	assert.True(t, subscribe)
+	unchecked, err := form.Bool("newsletter")
+	require.NoError(t, err)
+	assert.False(t, unchecked)
+
+	_, err = form.Int("q")
+	assert.ErrorIs(t, err, ERROR_BAD_FIELD)
+	_, err = form.Int("missing")
+	assert.ErrorIs(t, err, ERROR_MISSING_FIELD)
+}
+
+func TestURLEncodedErrors(t *testing.T) {
+	contentType := "application/x-www-form-urlencoded"
+
+	_, err := Parse(newRequest(t, "/", contentType, []byte("a=%zz")), Limits{})
+	assert.ErrorIs(t, err, ERROR_MALFORMED_FORM)
+
+	_, err = Parse(newRequest(t, "/", contentType, []byte("a=1&b=2&c=3")), Limits{MaxParts: 2})
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_PARTS)
+
+	_, err = Parse(newRequest(t, "/", contentType, []byte("a=12345")), Limits{MaxFieldSize: 4})
+	assert.ErrorIs(t, err, ERROR_FIELD_TOO_LARGE)
+
+	_, err = Parse(newRequest(t, "/", contentType, []byte("a=12345")), Limits{MaxTotalSize: 4})
+	assert.ErrorIs(t, err, ERROR_FORM_TOO_LARGE)
+
+	// a body still on the connection is cut off at the limit, not read whole
+	body := strings.Repeat("a", 4096)
+	req := headersOnly(t, contentType, strings.NewReader(body), len(body))
+	_, err = Parse(req, Limits{MaxTotalSize: 1024})
+	assert.ErrorIs(t, err, ERROR_FORM_TOO_LARGE)
+	assert.False(t, req.BodyRead())
+	assert.Equal(t, response.StatusContentTooLarge, server.NewHandlerError(err).StatusCode)
+}
+
+func TestQueryOnly(t *testing.T) {
+	form, err := Parse(newRequest(t, "/?a=1", "application/json", []byte(`{"a":2}`)), Limits{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1"}, form.All("a"))
+}
+
+func TestMultipart(t *testing.T) {
+	contentType, body := multipartBody(t,
+		[][2]string{{"title", "holiday"}, {"tag", "beach"}},
+		[][3]string{{"photo", "small.jpg", "tiny"}, {"photo", "big.jpg", strings.Repeat("x", 100)}},
+	)
+	req := newRequest(t, "/upload?tag=summer", contentType, body)
+
+	form, err := Parse(req, Limits{MaxMemory: 50})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+
+	assert.Equal(t, "holiday", form.Get("title"))
+	assert.Equal(t, []string{"beach", "summer"}, form.All("tag"))
+
+	photos := form.Files["photo"]
+	require.Len(t, photos, 2)
+	assert.Equal(t, "small.jpg", photos[0].Filename)
+	assert.Equal(t, "application/octet-stream", photos[0].ContentType)
+	assert.False(t, photos[0].OnDisk())
+	// past the memory budget the second file went to disk
+	assert.True(t, photos[1].OnDisk())
+	assert.Equal(t, int64(100), photos[1].Size)
+
+	for i, want := range []string{"tiny", strings.Repeat("x", 100)} {
+		f, err := photos[i].Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.Equal(t, want, string(content))
+	}
+
+	first, ok := form.File("photo")
+	require.True(t, ok)
+	assert.Equal(t, photos[0], first)
+
+	require.NoError(t, form.RemoveAll())
+	_, err = photos[1].Open()
+	assert.Error(t, err)
+}
+
+func TestMultipartLimits(t *testing.T) {
+	contentType, body := multipartBody(t,
+		[][2]string{{"a", "1"}, {"b", "22222"}, {"c", "3"}},
+		[][3]string{{"f", "f.txt", strings.Repeat("y", 64)}},
+	)
+
+	_, err := Parse(newRequest(t, "/", contentType, body), Limits{MaxParts: 3})
+	assert.ErrorIs(t, err, ERROR_TOO_MANY_PARTS)
+
+	_, err = Parse(newRequest(t, "/", contentType, body), Limits{MaxFieldSize: 4})
+	assert.ErrorIs(t, err, ERROR_FIELD_TOO_LARGE)
+
+	_, err = Parse(newRequest(t, "/", contentType, body), Limits{MaxTotalSize: int64(len(body) - 10)})
+	assert.ErrorIs(t, err, ERROR_FORM_TOO_LARGE)
+
+	_, err = Parse(newRequest(t, "/", contentType, body[:len(body)-10]), Limits{})
+	assert.ErrorIs(t, err, ERROR_MALFORMED_FORM)
+
+	_, err = Parse(newRequest(t, "/", "multipart/form-data", body), Limits{})
+	assert.ErrorIs(t, err, request.ERROR_BAD_BOUNDARY)
+}
+
+func TestParseMultipartStreams(t *testing.T) {
+	contentType, body := multipartBody(t, nil, [][3]string{{"f", "f.bin", strings.Repeat("z", 4096)}})
+	_, boundary, _ := strings.Cut(contentType, "boundary=")
+
+	// the parser reads as the body arrives rather than needing it whole
+	pr, pw := io.Pipe()
+	go func() {
+		for chunk := body; len(chunk) > 0; {
+			n := min(len(chunk), 100)
+			pw.Write(chunk[:n])
+			chunk = chunk[n:]
+		}
+		pw.Close()
+	}()
+
+	form, err := ParseMultipart(pr, boundary, Limits{MaxMemory: 1024})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	f, ok := form.File("f")
+	require.True(t, ok)
+	assert.True(t, f.OnDisk())
+	assert.Equal(t, int64(4096), f.Size)
+}
+
+// headersOnly is newRequest with the body left on the reader, as the
+// server hands requests to handlers.
+func headersOnly(t *testing.T, contentType string, body io.Reader, length int) *request.Request {
+	raw := "POST /upload HTTP/1.1\r\nHost: x\r\nContent-Length: " + strconv.Itoa(length) + "\r\nContent-Type: " + contentType + "\r\n\r\n"
+	req, err := request.RequestHeadersFromReader(io.MultiReader(strings.NewReader(raw), body))
+	require.NoError(t, err)
+	return req
+}
+
+func TestParseHeadersOnly(t *testing.T) {
+	body := "a=1&b=two"
+	form, err := Parse(headersOnly(t, "application/x-www-form-urlencoded", strings.NewReader(body), len(body)), Limits{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", form.Get("a"))
+	assert.Equal(t, "two", form.Get("b"))
+
+	contentType, multipartData := multipartBody(t, [][2]string{{"name", "gopher"}}, [][3]string{{"f", "f.bin", strings.Repeat("z", 4096)}})
+	pr, pw := io.Pipe()
+	go func() {
+		for chunk := multipartData; len(chunk) > 0; {
+			n := min(len(chunk), 100)
+			pw.Write(chunk[:n])
+			chunk = chunk[n:]
+		}
+		pw.Close()
+	}()
+	req := headersOnly(t, contentType, pr, len(multipartData))
+	form, err = Parse(req, Limits{MaxMemory: 1024})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	assert.Equal(t, "gopher", form.Get("name"))
+	f, ok := form.File("f")
+	require.True(t, ok)
+	assert.True(t, f.OnDisk())
+	assert.Equal(t, int64(4096), f.Size)
+	// the body went to the form without being collected in the request
+	assert.Empty(t, req.Body)
+}
@@ -0,0 +1,83 @@
+package headers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var seedFields = []string{
+	"Host: localhost:42069\r\n\r\n",
+	"Host:    localhost:42069 \t   \r\nUser-Agent: curl/7.64.1\r\n\r\n",
+	"Cache-Control: no-cache\r\nCache-Control: max-age=0\r\n\r\n",
+	"Cookie: a=1\r\nCookie: b=2\r\n\r\n",
+	"X-Obs-Text: caf\xe9\r\n\r\n",
+	"Host : localhost\r\n\r\n",
+	"Héllo: world\r\n\r\n",
+	"X-Folded: one\r\n two\r\n\r\n",
+	"Host: a\nAccept: */*\n\n",
+	"Host: a\rb\r\n\r\n",
+	"Host: a\x00\r\n\r\n",
+	"Host\r\n\r\n",
+	"Host: a\r\n",
+}
+
+// FuzzHeadersParse feeds field blocks through Parse until the blank line
+// or an error, checking that whatever is accepted is well-formed and that
+// ParseView agrees with Parse.
+func FuzzHeadersParse(f *testing.F) {
+	for _, seed := range seedFields {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, total, done, err := parseAll(data, false)
+		view, viewTotal, viewDone, viewErr := parseAll(bytes.Clone(data), true)
+		require.Equal(t, err, viewErr)
+		require.Equal(t, total, viewTotal)
+		require.Equal(t, done, viewDone)
+		// view's strings alias its own copy of data, which is still intact
+		assert.Equal(t, h, view)
+
+		require.LessOrEqual(t, total, len(data))
+		if total > 0 {
+			assert.Equal(t, "\r\n", string(data[total-2:total]))
+		}
+		for name, value := range h {
+			require.NotEmpty(t, name)
+			assert.Equal(t, strings.ToLower(name), name)
+			for i := 0; i < len(name); i++ {
+				assert.True(t, isTChar(name[i]), "name %q", name)
+			}
+			assert.NotContains(t, value, "\r")
+			assert.NotContains(t, value, "\n")
+			assert.NotContains(t, value, "\x00")
+			assert.Equal(t, strings.Trim(value, " \t"), value)
+		}
+	})
+}
+
+func parseAll(data []byte, view bool) (Headers, int, bool, error) {
+	h := NewHeaders()
+	total := 0
+	for {
+		var n int
+		var done bool
+		var err error
+		if view {
+			n, done, err = h.ParseView(data[total:])
+		} else {
+			n, done, err = h.Parse(data[total:])
+		}
+		if err != nil {
+			return h, total, false, err
+		}
+		total += n
+		if done || n == 0 {
+			return h, total, done, nil
+		}
+	}
+}
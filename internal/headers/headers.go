@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"unsafe"
 )
 
 type Headers map[string]string
@@ -24,6 +25,11 @@ func (h Headers) Add(key string, value string) {
 var ERROR_BAD_FIELD_LINE_FORMAT = fmt.Errorf("bad field line format")
 var ERROR_BAD_FIELD_LINE_NAME = fmt.Errorf("bad field line name")
 var ERROR_BAD_FIELD_LINE_VALUE = fmt.Errorf("bad field line value")
+var ERROR_WHITESPACE_BEFORE_COLON = fmt.Errorf("whitespace between field name and colon")
+var ERROR_OBS_FOLD = fmt.Errorf("obsolete line folding")
+var ERROR_BARE_LF = fmt.Errorf("line terminated by bare LF")
+var ERROR_BARE_CR = fmt.Errorf("bare CR not followed by LF")
+var ERROR_NUL_BYTE = fmt.Errorf("NUL byte in message")
 
 func isTChar(b byte) bool {
 	if b >= 'a' && b <= 'z' {
@@ -46,34 +52,72 @@ func isTChar(b byte) bool {
 	return false
 }
 
-func formatFieldName(data []byte) []byte {
-	leftSpaces := 0
-	for leftSpaces < len(data) && data[leftSpaces] == ' ' {
-		leftSpaces++
+// commonHeaders interns the field names most requests carry, so parsing
+// them costs no allocation.
+var commonHeaders = map[string]string{}
+
+func init() {
+	for _, name := range []string{
+		"accept", "accept-encoding", "accept-language", "authorization",
+		"cache-control", "connection", "content-encoding", "content-length",
+		"content-type", "cookie", "expect", "host", "if-match",
+		"if-modified-since", "if-none-match", "if-unmodified-since", "origin",
+		"pragma", "proxy-authorization", "range", "referer", "te",
+		"traceparent", "transfer-encoding", "upgrade", "user-agent",
+		"x-forwarded-for", "x-request-id",
+	} {
+		commonHeaders[name] = name
+	}
+}
+
+// formatFieldName validates a field name and returns it lowercased, or ""
+// if it is invalid. With view set the name is lowercased in place and the
+// result aliases data.
+func formatFieldName(data []byte, view bool) string {
+	if len(data) == 0 {
+		return ""
 	}
-	data = data[leftSpaces:]
 	for _, c := range data {
 		if !isTChar(c) {
-			return nil
+			return ""
 		}
 	}
 
-	if len(data) == 0 {
-		return nil
+	var lower [32]byte
+	if len(data) <= len(lower) {
+		for i, c := range data {
+			lower[i] = toLower(c)
+		}
+		if name, ok := commonHeaders[string(lower[:len(data)])]; ok {
+			return name
+		}
+	}
+
+	if !view {
+		return string(bytes.ToLower(data))
 	}
+	for i, c := range data {
+		data[i] = toLower(c)
+	}
+	return unsafe.String(&data[0], len(data))
+}
 
-	return bytes.ToLower(data)
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
 }
 
 func formatFieldValue(data []byte) []byte {
 	i := 0
-	for i < len(data) && data[i] == ' ' {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\t') {
 		i++
 	}
 	data = data[i:]
 
 	i = len(data)
-	for i >= 0 && data[i-1] == ' ' {
+	for i > 0 && (data[i-1] == ' ' || data[i-1] == '\t') {
 		i--
 	}
 	data = data[:i]
@@ -82,32 +126,93 @@ func formatFieldValue(data []byte) []byte {
 		return nil
 	}
 
+	for _, c := range data {
+		if !isFieldVChar(c) {
+			return nil
+		}
+	}
+
 	return data
 }
 
+// field values are visible ASCII, spaces, tabs and obs-text
+func isFieldVChar(b byte) bool {
+	return b == '\t' || (b >= ' ' && b != 0x7f)
+}
+
+// NextLine returns the line at the start of data without its CRLF and the
+// number of bytes it spans, or n == 0 if no full line is buffered yet. Any
+// LF must be part of a CRLF, and NUL or bare CR are never allowed.
+func NextLine(data []byte) (line []byte, n int, err error) {
+	idx := bytes.IndexByte(data, '\n')
+	if idx == -1 {
+		return nil, 0, nil
+	}
+	if idx == 0 || data[idx-1] != '\r' {
+		return nil, 0, ERROR_BARE_LF
+	}
+
+	line = data[:idx-1]
+	if bytes.IndexByte(line, 0) != -1 {
+		return nil, 0, ERROR_NUL_BYTE
+	}
+	if bytes.IndexByte(line, '\r') != -1 {
+		return nil, 0, ERROR_BARE_CR
+	}
+
+	return line, idx + 1, nil
+}
+
+// listSeparator joins repeated fields into one value. Cookie is not a
+// comma-separated list, so repeated Cookie lines are joined the way RFC
+// 9113 section 8.2.3 does it.
+func listSeparator(fieldName string) string {
+	if fieldName == "cookie" {
+		return "; "
+	}
+	return ", "
+}
+
 var HEADER_SEPARATOR = []byte("\r\n")
 var LINE_SEPARATOR = []byte(":")
 
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	idx := bytes.Index(data, HEADER_SEPARATOR)
-	if idx == -1 {
-		return 0, false, nil
+	return h.parse(data, false)
+}
+
+// ParseView is Parse without copying: names and values alias data, which
+// must stay unmodified for as long as h is in use.
+func (h Headers) ParseView(data []byte) (n int, done bool, err error) {
+	return h.parse(data, true)
+}
+
+func (h Headers) parse(data []byte, view bool) (n int, done bool, err error) {
+	line, n, err := NextLine(data)
+	if err != nil || n == 0 {
+		return 0, false, err
 	}
 
-	n = idx + len(HEADER_SEPARATOR)
-	if idx == 0 {
+	if len(line) == 0 {
 		return n, true, nil
 	}
 
-	line := data[:idx]
+	// a field line starting with whitespace continues the previous one
+	// (obs-fold); RFC 9112 section 5.2 lets us reject it
+	if line[0] == ' ' || line[0] == '\t' {
+		return 0, false, ERROR_OBS_FOLD
+	}
 
 	idxSeparator := bytes.Index(line, LINE_SEPARATOR)
 	if idxSeparator == -1 {
 		return 0, false, ERROR_BAD_FIELD_LINE_FORMAT
 	}
 
-	fieldName := formatFieldName(line[:idxSeparator])
-	if fieldName == nil {
+	if idxSeparator > 0 && (line[idxSeparator-1] == ' ' || line[idxSeparator-1] == '\t') {
+		return 0, false, ERROR_WHITESPACE_BEFORE_COLON
+	}
+
+	fieldName := formatFieldName(line[:idxSeparator], view)
+	if fieldName == "" {
 		return 0, false, ERROR_BAD_FIELD_LINE_NAME
 	}
 
@@ -116,15 +221,18 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		return 0, false, ERROR_BAD_FIELD_LINE_VALUE
 	}
 
-	strName := string(fieldName)
-	strValue := string(fieldValue)
-
-	curr, exists := h.Get(strName)
+	var value string
+	if view {
+		value = unsafe.String(&fieldValue[0], len(fieldValue))
+	} else {
+		value = string(fieldValue)
+	}
 
-	if !exists {
-		h.Add(strName, strValue)
+	// fieldName is already lowercase, so skip Get and Add
+	if curr, exists := h[fieldName]; exists {
+		h[fieldName] = curr + listSeparator(fieldName) + value
 	} else {
-		h.Add(strName, curr + ", " + strValue)
+		h[fieldName] = value
 	}
 
 	return n, false, nil
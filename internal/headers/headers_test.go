@@ -36,13 +36,24 @@ func TestHeaders(t *testing.T) {
 
 	t.Run("Valid single header with extra whitespace", func(t *testing.T) {
 		headers := NewHeaders()
-		data := []byte("     Host:    localhost:42069     \r\n\r\n")
+		data := []byte("Host:    localhost:42069 \t   \r\n\r\n")
 
 		n, done, err := headers.Parse(data)
 
 		require.NoError(t, err)
 		assert.Equal(t, "localhost:42069", headers["host"])
-		assert.Equal(t, 36, n)
+		assert.Equal(t, 31, n)
+		assert.False(t, done)
+	})
+
+	t.Run("Invalid leading whitespace (obs-fold)", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("     Host:    localhost:42069     \r\n\r\n")
+
+		n, done, err := headers.Parse(data)
+
+		require.ErrorIs(t, err, ERROR_OBS_FOLD)
+		assert.Equal(t, 0, n)
 		assert.False(t, done)
 	})
 
@@ -66,6 +77,18 @@ func TestHeaders(t *testing.T) {
 		assert.Equal(t, 24, n) // "Connection: keep-alive\r\n"
 	})
 
+	t.Run("Valid repeated cookie lines", func(t *testing.T) {
+		headers := NewHeaders()
+
+		for _, line := range []string{"Cookie: a=1\r\n", "Cookie: b=2; c=3\r\n", "Accept: text/html\r\n", "Accept: */*\r\n"} {
+			_, _, err := headers.Parse([]byte(line))
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, "a=1; b=2; c=3", headers["cookie"])
+		assert.Equal(t, "text/html, */*", headers["accept"])
+	})
+
 	t.Run("Valid done", func(t *testing.T) {
 		headers := NewHeaders()
 		data := []byte("\r\n")
@@ -88,6 +111,42 @@ func TestHeaders(t *testing.T) {
 		assert.False(t, done)
 	})
 
+	t.Run("Invalid whitespace before colon", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host : localhost:42069\r\n\r\n")
+
+		_, _, err := headers.Parse(data)
+
+		require.ErrorIs(t, err, ERROR_WHITESPACE_BEFORE_COLON)
+	})
+
+	t.Run("Invalid bare LF", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host: localhost\nX-Smuggled: yes\r\n\r\n")
+
+		_, _, err := headers.Parse(data)
+
+		require.ErrorIs(t, err, ERROR_BARE_LF)
+	})
+
+	t.Run("Invalid NUL in value", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host: local\x00host\r\n\r\n")
+
+		_, _, err := headers.Parse(data)
+
+		require.ErrorIs(t, err, ERROR_NUL_BYTE)
+	})
+
+	t.Run("Invalid whitespace-only value", func(t *testing.T) {
+		headers := NewHeaders()
+		data := []byte("Host:    \r\n\r\n")
+
+		_, _, err := headers.Parse(data)
+
+		require.ErrorIs(t, err, ERROR_BAD_FIELD_LINE_VALUE)
+	})
+
 	t.Run("Invalid character in header key", func(t *testing.T) {
 		headers := NewHeaders()
 		data := []byte("H©st: localhost:42069\r\n\r\n")
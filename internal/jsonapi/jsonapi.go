@@ -0,0 +1,242 @@
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+const ContentType = "application/json; charset=utf-8"
+const NDJSONContentType = "application/x-ndjson"
+
+// DefaultMaxSize is the body limit Bind uses unless told otherwise.
+const DefaultMaxSize = 1 << 20
+
+var ERROR_NOT_JSON = fmt.Errorf("content-type is not application/json")
+var ERROR_JSON_TOO_LARGE = fmt.Errorf("json body larger than limit")
+var ERROR_EMPTY_BODY = fmt.Errorf("empty json body")
+var ERROR_TRAILING_DATA = fmt.Errorf("data after the json value")
+
+// DecodeError is why Bind refused a body, with the response status it
+// calls for and, for malformed JSON, where in the body the problem is.
+type DecodeError struct {
+	Status response.StatusCode
+	// Offset counts bytes from the start of the body; Line and Column are
+	// 1-based. All three are zero when the error has no position.
+	Offset int64
+	Line   int
+	Column int
+	// Field is the dotted path of a field with the wrong type, if known.
+	Field string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%v (line %d, column %d)", e.Err, e.Line, e.Column)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecodeError) StatusCode() response.StatusCode {
+	return e.Status
+}
+
+type Options struct {
+	// MaxSize caps the body; zero means DefaultMaxSize.
+	MaxSize int
+	// AllowUnknownFields accepts object keys v has no field for.
+	AllowUnknownFields bool
+}
+
+// Bind decodes the request's JSON body into v. It insists on a JSON
+// Content-Type, a body within MaxSize, exactly one JSON value and, unless
+// allowed, no unknown fields. Failures are *DecodeError.
+func Bind(req *request.Request, v any, opts Options) error {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultMaxSize
+	}
+
+	mediaType, _, err := req.ContentType()
+	if err != nil || (mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json")) {
+		return &DecodeError{Status: response.StatusUnsupportedMedia, Err: ERROR_NOT_JSON}
+	}
+
+	// refuse a declared oversized body before reading it
+	if value, ok := req.Headers.Get("content-length"); ok {
+		if n, err := strconv.Atoi(value); err == nil && n > opts.MaxSize {
+			return &DecodeError{Status: response.StatusContentTooLarge, Err: ERROR_JSON_TOO_LARGE}
+		}
+	}
+	// and hold a chunked or undeclared one to the limit while reading it
+	bodyReader, err := req.BodyReader()
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(io.LimitReader(bodyReader, int64(opts.MaxSize)+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > opts.MaxSize {
+		return &DecodeError{Status: response.StatusContentTooLarge, Err: ERROR_JSON_TOO_LARGE}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &DecodeError{Status: response.StatusBadRequest, Err: ERROR_EMPTY_BODY}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if !opts.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return decodeError(body, err, dec.InputOffset())
+	}
+	offset := dec.InputOffset()
+	if _, err := dec.Token(); err != io.EOF {
+		// point at the first byte after the value that is not whitespace
+		offset += int64(len(body[offset:]) - len(bytes.TrimLeft(body[offset:], " \t\r\n")))
+		return decodeError(body, ERROR_TRAILING_DATA, offset)
+	}
+	return nil
+}
+
+// encoding/json has no error type for unknown fields, only this message
+const unknownFieldPrefix = "json: unknown field "
+
+// decodeError locates err in body. The decoder's own offset is the
+// fallback for errors, such as unknown fields, that carry none.
+func decodeError(body []byte, err error, inputOffset int64) *DecodeError {
+	e := &DecodeError{Status: response.StatusBadRequest, Offset: inputOffset, Err: err}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// Offset counts the offending byte as read
+		e.Offset = max(0, syntaxErr.Offset-1)
+	case errors.As(err, &typeErr):
+		e.Offset = typeErr.Offset
+		e.Field = typeErr.Field
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		e.Offset = int64(len(body))
+	case strings.HasPrefix(err.Error(), unknownFieldPrefix):
+		// the decoder stops at the end of the object holding the field, so
+		// look back for the key itself
+		key := strings.TrimPrefix(err.Error(), unknownFieldPrefix)
+		if at := bytes.LastIndex(body[:inputOffset], []byte(key+":")); at != -1 {
+			e.Offset = int64(at)
+		}
+	}
+
+	e.Line, e.Column = position(body, e.Offset)
+	return e
+}
+
+func position(body []byte, offset int64) (line, column int) {
+	offset = min(offset, int64(len(body)))
+	before := body[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, column
+}
+
+// Write renders v as a JSON response with the given status.
+func Write(w *response.Writer, statusCode response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	body = append(body, '\n')
+
+	h := response.GetDefaultHeaders(len(body))
+	h.Add("content-type", ContentType)
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	_, err = w.WriteBody(body)
+	return err
+}
+
+// WriteError renders err as {"error": ...}, with the position of a
+// *DecodeError, and the status it calls for; other errors are a 500.
+func WriteError(w *response.Writer, err error) error {
+	body := struct {
+		Error  string `json:"error"`
+		Line   int    `json:"line,omitempty"`
+		Column int    `json:"column,omitempty"`
+		Field  string `json:"field,omitempty"`
+	}{Error: err.Error()}
+	statusCode := response.StatusInternalServerError
+
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		statusCode = decodeErr.Status
+		body.Error = decodeErr.Err.Error()
+		body.Line, body.Column, body.Field = decodeErr.Line, decodeErr.Column, decodeErr.Field
+	}
+	return Write(w, statusCode, body)
+}
+
+// Stream writes a newline-delimited JSON response, one value per line,
+// each reaching the client as soon as it is sent.
+type Stream struct {
+	w *response.Writer
+}
+
+func NewStream(w *response.Writer, statusCode response.StatusCode) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Add("content-type", NDJSONContentType)
+	h.Add("transfer-encoding", "chunked")
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	return &Stream{w: w}, nil
+}
+
+func (s *Stream) Send(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.WriteChunkedBody(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Close ends the response.
+func (s *Stream) Close() error {
+	return s.w.WriteChunkedBodyDone()
+}
+
+// WriteNDJSON streams items as an NDJSON response.
+func WriteNDJSON[T any](w *response.Writer, statusCode response.StatusCode, items []T) error {
+	stream, err := NewStream(w, statusCode)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := stream.Send(item); err != nil {
+			return err
+		}
+	}
+	return stream.Close()
+}
@@ -0,0 +1,151 @@
+package jsonapi
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type user struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+	Tags []struct {
+		ID int `json:"id"`
+	} `json:"tags"`
+}
+
+func newRequest(t *testing.T, contentType, body string) *request.Request {
+	raw := "POST /users HTTP/1.1\r\nHost: x\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n"
+	if contentType != "" {
+		raw += "Content-Type: " + contentType + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n" + body))
+	require.NoError(t, err)
+	return req
+}
+
+func TestBind(t *testing.T) {
+	var u user
+	err := Bind(newRequest(t, "application/json; charset=utf-8", `{"name":"ada","age":36,"tags":[{"id":1}]}`), &u, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "ada", u.Name)
+	assert.Equal(t, 36, u.Age)
+
+	err = Bind(newRequest(t, "application/merge-patch+json", `{"age":37}`), &u, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 37, u.Age)
+
+	err = Bind(newRequest(t, "application/json", `{"name":"ada","admin":true}`), &u, Options{AllowUnknownFields: true})
+	assert.NoError(t, err)
+}
+
+func TestBindErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		opts        Options
+		status      response.StatusCode
+		err         error
+		line        int
+		column      int
+		field       string
+	}{
+		{name: "no content-type", body: `{}`, status: response.StatusUnsupportedMedia, err: ERROR_NOT_JSON},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: `a=1`, status: response.StatusUnsupportedMedia, err: ERROR_NOT_JSON},
+		{name: "too large", contentType: "application/json", body: `{"name":"a very long name"}`, opts: Options{MaxSize: 10}, status: response.StatusContentTooLarge, err: ERROR_JSON_TOO_LARGE},
+		{name: "empty", contentType: "application/json", body: "  ", status: response.StatusBadRequest, err: ERROR_EMPTY_BODY},
+		{name: "trailing data", contentType: "application/json", body: `{"name":"a"} {}`, status: response.StatusBadRequest, err: ERROR_TRAILING_DATA, line: 1, column: 14},
+		{name: "stray bracket", contentType: "application/json", body: `{} ]`, status: response.StatusBadRequest, err: ERROR_TRAILING_DATA, line: 1, column: 4},
+		{name: "syntax", contentType: "application/json", body: "{\n  \"name\": \"a\",\n  \"age\": x\n}", status: response.StatusBadRequest, line: 3, column: 10},
+		{name: "type", contentType: "application/json", body: "{\n\"name\": 7}", status: response.StatusBadRequest, line: 2, column: 10, field: "name"},
+		{name: "unknown field", contentType: "application/json", body: `{"name":"admin","admin":true}`, status: response.StatusBadRequest, line: 1, column: 17},
+		{name: "truncated", contentType: "application/json", body: `{"name":`, status: response.StatusBadRequest, line: 1, column: 9},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var u user
+			err := Bind(newRequest(t, tc.contentType, tc.body), &u, tc.opts)
+
+			var decodeErr *DecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			assert.Equal(t, tc.status, decodeErr.Status)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			}
+			assert.Equal(t, tc.line, decodeErr.Line)
+			assert.Equal(t, tc.column, decodeErr.Column)
+			assert.Equal(t, tc.field, decodeErr.Field)
+		})
+	}
+}
+
+func TestBindChunkedTooLarge(t *testing.T) {
+	// 2 MiB of whitespace in 1 KiB chunks, which declares no length
+	chunk := "400\r\n" + strings.Repeat(" ", 1024) + "\r\n"
+	raw := "POST /users HTTP/1.1\r\nHost: x\r\nContent-Type: application/json\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		strings.Repeat(chunk, 2048) + "0\r\n\r\n"
+	req, err := request.RequestHeadersFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+
+	var u user
+	err = Bind(req, &u, Options{})
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	assert.Equal(t, response.StatusContentTooLarge, decodeErr.Status)
+	// the limit stopped the read instead of checking what it buffered
+	assert.False(t, req.BodyRead())
+	assert.Equal(t, response.StatusContentTooLarge, server.NewHandlerError(err).StatusCode)
+}
+
+// collect runs write on a Writer and parses what it produced.
+func collect(t *testing.T, write func(w *response.Writer)) (*http.Response, string) {
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer serverSide.Close()
+		write(response.NewWriter(serverSide, nil))
+	}()
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestWrite(t *testing.T) {
+	resp, body := collect(t, func(w *response.Writer) {
+		assert.NoError(t, Write(w, response.StatusOk, map[string]int{"count": 3}))
+	})
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, int64(len(body)), resp.ContentLength)
+	assert.Equal(t, "{\"count\":3}\n", body)
+}
+
+func TestWriteError(t *testing.T) {
+	var u user
+	err := Bind(newRequest(t, "application/json", "{\n\"age\": \"old\"}"), &u, Options{})
+	resp, body := collect(t, func(w *response.Writer) {
+		assert.NoError(t, WriteError(w, err))
+	})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"json: cannot unmarshal string into Go struct field user.age of type int","line":2,"column":13,"field":"age"}`, body)
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	resp, body := collect(t, func(w *response.Writer) {
+		assert.NoError(t, WriteNDJSON(w, response.StatusOk, []user{{Name: "ada"}, {Name: "alan"}}))
+	})
+	assert.Equal(t, NDJSONContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "{\"name\":\"ada\",\"age\":0,\"tags\":null}\n{\"name\":\"alan\",\"age\":0,\"tags\":null}\n", body)
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var ERROR_LABEL_COUNT = fmt.Errorf("wrong number of label values")
+
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+type Gauge struct {
+	value atomic.Int64
+}
+
+func (g *Gauge) Inc() {
+	g.value.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.value.Add(-1)
+}
+
+func (g *Gauge) Set(n int64) {
+	g.value.Store(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// CounterVec is a family of counters told apart by label values.
+type CounterVec struct {
+	labels []string
+
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// With returns the counter for values, given in the order the labels were
+// registered, creating it on first use.
+func (v *CounterVec) With(values ...string) *Counter {
+	if len(values) != len(v.labels) {
+		panic(ERROR_LABEL_COUNT)
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[key]
+	if !ok {
+		c = &Counter{}
+		v.counters[key] = c
+	}
+	return c
+}
+
+type Histogram struct {
+	// upper bounds, ascending, without +Inf
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// Count is how many values have been observed.
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	expose func(b *strings.Builder, name string)
+}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, kind string, expose func(b *strings.Builder, name string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, metric{name: name, help: help, kind: kind, expose: expose})
+}
+
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", func(b *strings.Builder, name string) {
+		writeSample(b, name, "", strconv.FormatUint(c.Value(), 10))
+	})
+	return c
+}
+
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", func(b *strings.Builder, name string) {
+		writeSample(b, name, "", strconv.FormatInt(g.Value(), 10))
+	})
+	return g
+}
+
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{labels: labels, counters: map[string]*Counter{}}
+	r.register(name, help, "counter", func(b *strings.Builder, name string) {
+		v.mu.Lock()
+		keys := make([]string, 0, len(v.counters))
+		for key := range v.counters {
+			keys = append(keys, key)
+		}
+		values := make([]uint64, len(keys))
+		slices.Sort(keys)
+		for i, key := range keys {
+			values[i] = v.counters[key].Value()
+		}
+		v.mu.Unlock()
+
+		for i, key := range keys {
+			writeSample(b, name, formatLabels(v.labels, strings.Split(key, "\xff")), strconv.FormatUint(values[i], 10))
+		}
+	})
+	return v
+}
+
+// Histogram registers a histogram with the given bucket upper bounds,
+// which must be ascending; a +Inf bucket is always added.
+func (r *Registry) Histogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+	r.register(name, help, "histogram", func(b *strings.Builder, name string) {
+		h.mu.Lock()
+		counts := slices.Clone(h.counts)
+		sum, count := h.sum, h.count
+		h.mu.Unlock()
+
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += counts[i]
+			writeSample(b, name+"_bucket", formatLabels([]string{"le"}, []string{formatFloat(bound)}), strconv.FormatUint(cumulative, 10))
+		}
+		writeSample(b, name+"_bucket", `{le="+Inf"}`, strconv.FormatUint(count, 10))
+		writeSample(b, name+"_sum", "", formatFloat(sum))
+		writeSample(b, name+"_count", "", strconv.FormatUint(count, 10))
+	})
+	return h
+}
+
+// Expose renders every registered metric in registration order.
+func (r *Registry) Expose() []byte {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		b.WriteString("# HELP " + m.name + " " + escapeHelp(m.help) + "\n")
+		b.WriteString("# TYPE " + m.name + " " + m.kind + "\n")
+		m.expose(&b, m.name)
+	}
+	return []byte(b.String())
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(r.Expose())
+	return int64(n), err
+}
+
+func writeSample(b *strings.Builder, name, labels, value string) {
+	b.WriteString(name + labels + " " + value + "\n")
+}
+
+func formatLabels(names, values []string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpose(t *testing.T) {
+	r := NewRegistry()
+	conns := r.Gauge("conns", "Open connections.")
+	requests := r.CounterVec("requests_total", "Requests by method.", "method", "path")
+	latency := r.Histogram("latency_seconds", "Latency.", []float64{0.1, 1})
+
+	conns.Inc()
+	conns.Inc()
+	conns.Dec()
+	requests.With("GET", "/").Add(2)
+	requests.With("GET", `/"quoted"`).Inc()
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.Observe(3)
+
+	assert.Equal(t, `# HELP conns Open connections.
+# TYPE conns gauge
+conns 1
+# HELP requests_total Requests by method.
+# TYPE requests_total counter
+requests_total{method="GET",path="/"} 2
+requests_total{method="GET",path="/\"quoted\""} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.15
+latency_seconds_count 3
+`, string(r.Expose()))
+}
+
+func TestCounterVecLabelCount(t *testing.T) {
+	v := NewRegistry().CounterVec("x_total", "x", "a", "b")
+	assert.PanicsWithValue(t, ERROR_LABEL_COUNT, func() { v.With("only one") })
+}
@@ -0,0 +1,248 @@
+package negotiate
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+// Accept is one element of an Accept* field: a media range, language
+// range or charset with its weight.
+type Accept struct {
+	Value  string
+	Q      float64
+	Params map[string]string
+}
+
+// ParseAccept reads an Accept, Accept-Language or Accept-Charset value.
+// Elements with a malformed weight are dropped. The result is sorted by
+// weight, keeping the client's order among equals.
+func ParseAccept(value string) []Accept {
+	var accepts []Accept
+	for _, element := range splitQuoted(value, ',') {
+		params := splitQuoted(element, ';')
+		value := strings.ToLower(strings.TrimSpace(params[0]))
+		if value == "" {
+			continue
+		}
+
+		accept := Accept{Value: value, Q: 1}
+		valid := true
+		for _, param := range params[1:] {
+			name, paramValue, _ := strings.Cut(param, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			paramValue = strings.Trim(strings.TrimSpace(paramValue), `"`)
+			if name == "q" {
+				accept.Q, valid = parseQ(paramValue)
+				// whatever follows the weight is an extension, not a
+				// media type parameter
+				break
+			}
+			if accept.Params == nil {
+				accept.Params = map[string]string{}
+			}
+			accept.Params[name] = paramValue
+		}
+		if valid {
+			accepts = append(accepts, accept)
+		}
+	}
+
+	sort.SliceStable(accepts, func(i, j int) bool { return accepts[i].Q > accepts[j].Q })
+	return accepts
+}
+
+// parseQ reads a weight: 0 or 1 with up to three decimals.
+func parseQ(value string) (float64, bool) {
+	if len(value) == 0 || len(value) > 5 || (value[0] != '0' && value[0] != '1') {
+		return 0, false
+	}
+	if len(value) > 1 && value[1] != '.' {
+		return 0, false
+	}
+	q, err := strconv.ParseFloat(value, 64)
+	if err != nil || q > 1 {
+		return 0, false
+	}
+	return q, true
+}
+
+// splitQuoted splits s at sep, except inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && inQuotes:
+			i++
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// matcher scores how specifically accept covers offer; 0 means it does not.
+type matcher func(accept Accept, offer string) int
+
+// best picks the offer the client weights highest, preferring earlier
+// offers among equals. Each offer takes the weight of the most specific
+// element covering it. No header at all accepts anything.
+func best(header string, present bool, offers []string, match matcher) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+	if !present {
+		return offers[0], true
+	}
+
+	accepts := ParseAccept(header)
+	bestOffer, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, 0
+		for _, accept := range accepts {
+			if s := match(accept, offer); s > specificity {
+				q, specificity = accept.Q, s
+			}
+		}
+		if q > bestQ {
+			bestOffer, bestQ = offer, q
+		}
+	}
+	return bestOffer, bestQ > 0
+}
+
+// MediaType picks from offers such as "application/json" by an Accept
+// value. present is whether the request had an Accept field at all.
+func MediaType(accept string, present bool, offers []string) (string, bool) {
+	return best(accept, present, offers, matchMediaType)
+}
+
+func matchMediaType(accept Accept, offer string) int {
+	offerType, offerParams, _ := strings.Cut(strings.ToLower(offer), ";")
+	offerMain, offerSub, _ := strings.Cut(strings.TrimSpace(offerType), "/")
+	acceptMain, acceptSub, _ := strings.Cut(accept.Value, "/")
+
+	specificity := 0
+	switch {
+	case acceptMain == "*" && acceptSub == "*":
+		specificity = 1
+	case acceptMain == offerMain && acceptSub == "*":
+		specificity = 2
+	case acceptMain == offerMain && acceptSub == offerSub:
+		specificity = 3
+	default:
+		return 0
+	}
+
+	// a range with parameters only covers offers with the same ones
+	if len(accept.Params) > 0 {
+		params := map[string]string{}
+		for _, param := range strings.Split(offerParams, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			params[strings.TrimSpace(name)] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+		for name, value := range accept.Params {
+			if !strings.EqualFold(params[name], value) {
+				return 0
+			}
+		}
+	}
+	return specificity + len(accept.Params)
+}
+
+// Language picks from offers such as "en-GB" by an Accept-Language value,
+// using RFC 4647 basic filtering: "en" covers "en-GB".
+func Language(acceptLanguage string, present bool, offers []string) (string, bool) {
+	return best(acceptLanguage, present, offers, matchLanguage)
+}
+
+func matchLanguage(accept Accept, offer string) int {
+	offer = strings.ToLower(offer)
+	switch {
+	case accept.Value == "*":
+		return 1
+	case offer == accept.Value, strings.HasPrefix(offer, accept.Value+"-"):
+		return 1 + len(accept.Value)
+	default:
+		return 0
+	}
+}
+
+// Charset picks from offers such as "utf-8" by an Accept-Charset value.
+func Charset(acceptCharset string, present bool, offers []string) (string, bool) {
+	return best(acceptCharset, present, offers, matchCharset)
+}
+
+func matchCharset(accept Accept, offer string) int {
+	switch {
+	case accept.Value == "*":
+		return 1
+	case strings.EqualFold(accept.Value, offer):
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Offers are what a handler can produce. Empty lists are not negotiated.
+type Offers struct {
+	MediaTypes []string
+	Languages  []string
+	Charsets   []string
+}
+
+type Result struct {
+	MediaType string
+	Language  string
+	Charset   string
+}
+
+// ContentType is the Content-Type value for the result.
+func (r Result) ContentType() string {
+	if r.Charset == "" {
+		return r.MediaType
+	}
+	return r.MediaType + "; charset=" + r.Charset
+}
+
+// Negotiate picks the best of each kind of offer for req. It adds a Vary
+// field naming the request fields it consulted, so caches keep the
+// variants apart, and returns a 406 HandlerError when the client accepts
+// none of some kind of offer.
+func Negotiate(w *response.Writer, req *request.Request, offers Offers) (Result, *server.HandlerError) {
+	var result Result
+	var vary []string
+	acceptable := true
+
+	pick := func(field string, offers []string, pickFn func(string, bool, []string) (string, bool)) string {
+		if len(offers) == 0 {
+			return ""
+		}
+		vary = append(vary, field)
+		value, present := req.Headers.Get(field)
+		choice, ok := pickFn(value, present, offers)
+		acceptable = acceptable && ok
+		return choice
+	}
+	result.MediaType = pick("Accept", offers.MediaTypes, MediaType)
+	result.Language = pick("Accept-Language", offers.Languages, Language)
+	result.Charset = pick("Accept-Charset", offers.Charsets, Charset)
+
+	if len(vary) > 0 {
+		// the headers are already out if this fails, and then so is Vary
+		w.AddHeader("vary", strings.Join(vary, ", "))
+	}
+	if !acceptable {
+		return result, &server.HandlerError{StatusCode: response.StatusNotAcceptable}
+	}
+	return result, nil
+}
@@ -0,0 +1,127 @@
+package negotiate
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAccept(t *testing.T) {
+	accepts := ParseAccept(`text/html;level=1, text/*;q=0.3, application/json;q=0.9;ext="a,b", */*;q=0.1, bad;q=2, image/png;q=0.9`)
+	require.Len(t, accepts, 5)
+	assert.Equal(t, Accept{Value: "text/html", Q: 1, Params: map[string]string{"level": "1"}}, accepts[0])
+	// equal weights keep the client's order
+	assert.Equal(t, "application/json", accepts[1].Value)
+	assert.Nil(t, accepts[1].Params)
+	assert.Equal(t, "image/png", accepts[2].Value)
+	assert.Equal(t, 0.3, accepts[3].Q)
+	assert.Equal(t, "*/*", accepts[4].Value)
+}
+
+func TestMediaType(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		offers []string
+		want   string
+		ok     bool
+	}{
+		{"application/json", []string{"text/html", "application/json"}, "application/json", true},
+		{"text/*;q=0.5, text/html", []string{"text/plain", "text/html"}, "text/html", true},
+		// the most specific range decides, however it is weighted
+		{"text/*, text/plain;q=0", []string{"text/plain"}, "", false},
+		{"*/*;q=0.1, application/json;q=0.5", []string{"text/html", "application/json"}, "application/json", true},
+		// equal weights go to the server's first choice
+		{"text/html, application/json", []string{"application/json", "text/html"}, "application/json", true},
+		{"text/html;level=1", []string{"text/html", "text/html;level=1"}, "text/html;level=1", true},
+		{"Application/JSON", []string{"application/json"}, "application/json", true},
+		{"image/png", []string{"text/html"}, "", false},
+	} {
+		got, ok := MediaType(tc.accept, true, tc.offers)
+		assert.Equal(t, tc.ok, ok, tc.accept)
+		assert.Equal(t, tc.want, got, tc.accept)
+	}
+
+	got, ok := MediaType("", false, []string{"text/html", "application/json"})
+	assert.True(t, ok)
+	assert.Equal(t, "text/html", got)
+}
+
+func TestLanguage(t *testing.T) {
+	got, ok := Language("fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5", true, []string{"en-GB", "fr-FR", "de"})
+	assert.True(t, ok)
+	assert.Equal(t, "fr-FR", got)
+
+	got, ok = Language("de-DE, en;q=0.5", true, []string{"de", "en-US"})
+	assert.True(t, ok)
+	assert.Equal(t, "en-US", got)
+
+	_, ok = Language("ja, *;q=0", true, []string{"en"})
+	assert.False(t, ok)
+}
+
+func TestCharset(t *testing.T) {
+	got, ok := Charset("iso-8859-1;q=0.5, UTF-8", true, []string{"iso-8859-1", "utf-8"})
+	assert.True(t, ok)
+	assert.Equal(t, "utf-8", got)
+
+	_, ok = Charset("iso-8859-1", true, []string{"utf-8"})
+	assert.False(t, ok)
+}
+
+// negotiate runs Negotiate on a request with the given fields and answers
+// it with the status Negotiate calls for.
+func negotiate(t *testing.T, fields string, offers Offers) (Result, *http.Response) {
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n" + fields + "\r\n"))
+	require.NoError(t, err)
+
+	var result Result
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer serverSide.Close()
+		w := response.NewWriter(serverSide, nil)
+		statusCode := response.StatusOk
+		r, handlerError := Negotiate(w, req, offers)
+		if handlerError != nil {
+			statusCode = handlerError.StatusCode
+		}
+		result = r
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	return result, resp
+}
+
+func TestNegotiate(t *testing.T) {
+	offers := Offers{
+		MediaTypes: []string{"application/json", "text/html"},
+		Languages:  []string{"en", "es"},
+		Charsets:   []string{"utf-8"},
+	}
+
+	result, resp := negotiate(t, "Accept: text/html\r\nAccept-Language: es-MX, es;q=0.9\r\n", offers)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, Result{MediaType: "text/html", Language: "es", Charset: "utf-8"}, result)
+	assert.Equal(t, "text/html; charset=utf-8", result.ContentType())
+	assert.Equal(t, "Accept, Accept-Language, Accept-Charset", resp.Header.Get("Vary"))
+
+	_, resp = negotiate(t, "Accept: image/png\r\n", offers)
+	assert.Equal(t, http.StatusNotAcceptable, resp.StatusCode)
+	assert.Equal(t, "Accept, Accept-Language, Accept-Charset", resp.Header.Get("Vary"))
+
+	// only what was negotiated is listed
+	_, resp = negotiate(t, "", Offers{MediaTypes: []string{"application/json"}})
+	assert.Equal(t, "Accept", resp.Header.Get("Vary"))
+}
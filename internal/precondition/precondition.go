@@ -0,0 +1,246 @@
+package precondition
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+var ERROR_BAD_HTTP_DATE = fmt.Errorf("malformed HTTP-date")
+
+// the preferred IMF-fixdate, then the obsolete RFC 850 and asctime forms
+// recipients must still accept
+const (
+	TimeFormat    = "Mon, 02 Jan 2006 15:04:05 GMT"
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// ParseHTTPDate reads an HTTP-date in any of its three formats.
+func ParseHTTPDate(value string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ERROR_BAD_HTTP_DATE
+}
+
+func FormatHTTPDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ETag is an entity-tag.
+type ETag struct {
+	Weak   bool
+	Opaque string
+}
+
+// StrongETag and WeakETag make entity-tags from an opaque value without
+// quotes.
+func StrongETag(opaque string) ETag { return ETag{Opaque: opaque} }
+func WeakETag(opaque string) ETag   { return ETag{Weak: true, Opaque: opaque} }
+
+func (e ETag) String() string {
+	if e.Weak {
+		return `W/"` + e.Opaque + `"`
+	}
+	return `"` + e.Opaque + `"`
+}
+
+func (e ETag) IsZero() bool {
+	return e == ETag{}
+}
+
+// StrongMatch is the strong comparison: neither tag may be weak.
+func (e ETag) StrongMatch(other ETag) bool {
+	return !e.Weak && !other.Weak && e.Opaque == other.Opaque
+}
+
+// WeakMatch is the weak comparison, which ignores weakness.
+func (e ETag) WeakMatch(other ETag) bool {
+	return e.Opaque == other.Opaque
+}
+
+// ParseETag reads one entity-tag.
+func ParseETag(value string) (ETag, bool) {
+	tag, rest, ok := cutETag(strings.TrimSpace(value))
+	return tag, ok && rest == ""
+}
+
+// cutETag reads an entity-tag from the front of s.
+func cutETag(s string) (ETag, string, bool) {
+	var tag ETag
+	if strings.HasPrefix(s, "W/") {
+		tag.Weak = true
+		s = s[2:]
+	}
+	if len(s) < 2 || s[0] != '"' {
+		return ETag{}, s, false
+	}
+	end := strings.IndexByte(s[1:], '"')
+	if end == -1 {
+		return ETag{}, s, false
+	}
+	tag.Opaque = s[1 : end+1]
+	for i := 0; i < len(tag.Opaque); i++ {
+		// etagc is visible ASCII but DQUOTE, plus obs-text
+		if c := tag.Opaque[i]; c < 0x21 || c == 0x7f {
+			return ETag{}, s, false
+		}
+	}
+	return tag, s[end+2:], true
+}
+
+// parseETagList reads the value of If-Match or If-None-Match: "*" or a
+// comma-separated list of entity-tags. A malformed list reports ok false.
+func parseETagList(value string) (tags []ETag, any bool, ok bool) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return nil, true, true
+	}
+	for value != "" {
+		tag, rest, ok := cutETag(value)
+		if !ok {
+			return nil, false, false
+		}
+		tags = append(tags, tag)
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			break
+		}
+		if rest[0] != ',' {
+			return nil, false, false
+		}
+		value = strings.TrimLeft(rest[1:], " \t,")
+	}
+	return tags, false, true
+}
+
+// Validators describe the current representation of the target resource.
+type Validators struct {
+	ETag         ETag
+	LastModified time.Time
+	// Missing is set when the target has no current representation, as
+	// when a PUT would create it, so "*" does not match.
+	Missing bool
+}
+
+// Outcome is what the preconditions call for: Status is
+// StatusNotModified or StatusPreconditionFailed to stop short, or "" to
+// carry on. HonorRange reports whether a Range field may be served.
+type Outcome struct {
+	Status     response.StatusCode
+	HonorRange bool
+}
+
+// Evaluate checks the request's preconditions against v in the order of
+// RFC 9110 section 13.2.2.
+func Evaluate(req *request.Request, v Validators) Outcome {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	lastModified := v.LastModified.Truncate(time.Second)
+
+	// step 1 and 2: the state the client expects to change
+	if value, ok := req.Headers.Get("if-match"); ok {
+		if !matchAny(value, v, ETag.StrongMatch) {
+			return Outcome{Status: response.StatusPreconditionFailed}
+		}
+	} else if value, ok := req.Headers.Get("if-unmodified-since"); ok && !v.LastModified.IsZero() {
+		if date, err := ParseHTTPDate(value); err == nil && lastModified.After(date) {
+			return Outcome{Status: response.StatusPreconditionFailed}
+		}
+	}
+
+	// step 3 and 4: whether the client's copy is still current
+	if value, ok := req.Headers.Get("if-none-match"); ok {
+		if matchAny(value, v, ETag.WeakMatch) {
+			if safe {
+				return Outcome{Status: response.StatusNotModified}
+			}
+			return Outcome{Status: response.StatusPreconditionFailed}
+		}
+	} else if value, ok := req.Headers.Get("if-modified-since"); ok && safe && !v.LastModified.IsZero() {
+		if date, err := ParseHTTPDate(value); err == nil && !lastModified.After(date) {
+			return Outcome{Status: response.StatusNotModified}
+		}
+	}
+
+	// step 5: a range request only makes sense on the client's version
+	outcome := Outcome{}
+	if _, ok := req.Headers.Get("range"); ok && method == "GET" {
+		outcome.HonorRange = true
+		if value, ok := req.Headers.Get("if-range"); ok {
+			outcome.HonorRange = ifRange(value, v)
+		}
+	}
+	return outcome
+}
+
+// matchAny evaluates an If-Match or If-None-Match value. A malformed one
+// matches nothing.
+func matchAny(value string, v Validators, match func(ETag, ETag) bool) bool {
+	tags, any, ok := parseETagList(value)
+	if !ok || v.Missing {
+		return false
+	}
+	if any {
+		return true
+	}
+	if v.ETag.IsZero() {
+		return false
+	}
+	for _, tag := range tags {
+		if match(tag, v.ETag) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRange holds for a strong match on the entity-tag, or for the exact
+// Last-Modified date.
+func ifRange(value string, v Validators) bool {
+	if tag, ok := ParseETag(value); ok {
+		return !v.ETag.IsZero() && tag.StrongMatch(v.ETag)
+	}
+	date, err := ParseHTTPDate(value)
+	return err == nil && !v.LastModified.IsZero() && v.LastModified.Truncate(time.Second).Equal(date)
+}
+
+// Headers returns the ETag and Last-Modified fields for v.
+func Headers(v Validators) headers.Headers {
+	h := headers.NewHeaders()
+	if !v.ETag.IsZero() {
+		h.Add("etag", v.ETag.String())
+	}
+	if !v.LastModified.IsZero() {
+		h.Add("last-modified", FormatHTTPDate(v.LastModified))
+	}
+	return h
+}
+
+// Check evaluates the preconditions and, when they call for stopping
+// short, writes the 304 or 412 response itself and reports true; the
+// handler then has nothing left to do.
+func Check(w *response.Writer, req *request.Request, v Validators) (Outcome, bool, error) {
+	outcome := Evaluate(req, v)
+	if outcome.Status == "" {
+		return outcome, false, nil
+	}
+
+	h := Headers(v)
+	// a 304 stands in for the representation, so it carries its
+	// validators and no content-length of its own
+	if outcome.Status == response.StatusPreconditionFailed {
+		h.Add("content-length", "0")
+	}
+	if err := w.WriteStatusLine(outcome.Status); err != nil {
+		return outcome, true, err
+	}
+	return outcome, true, w.WriteHeaders(h)
+}
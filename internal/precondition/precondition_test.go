@@ -0,0 +1,177 @@
+package precondition
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHTTPDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+	for _, value := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT",
+		"Sun Nov  6 08:49:37 1994",
+	} {
+		got, err := ParseHTTPDate(value)
+		require.NoError(t, err, value)
+		assert.True(t, want.Equal(got), value)
+	}
+
+	_, err := ParseHTTPDate("06 Nov 1994")
+	assert.ErrorIs(t, err, ERROR_BAD_HTTP_DATE)
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatHTTPDate(want.In(time.FixedZone("X", 3600))))
+}
+
+func TestETagComparison(t *testing.T) {
+	for _, tc := range []struct {
+		a, b         string
+		strong, weak bool
+	}{
+		{`W/"1"`, `W/"1"`, false, true},
+		{`W/"1"`, `W/"2"`, false, false},
+		{`W/"1"`, `"1"`, false, true},
+		{`"1"`, `"1"`, true, true},
+	} {
+		a, ok := ParseETag(tc.a)
+		require.True(t, ok, tc.a)
+		b, ok := ParseETag(tc.b)
+		require.True(t, ok, tc.b)
+		assert.Equal(t, tc.strong, a.StrongMatch(b), "%s %s", tc.a, tc.b)
+		assert.Equal(t, tc.weak, a.WeakMatch(b), "%s %s", tc.a, tc.b)
+	}
+
+	for _, value := range []string{`1`, `"1`, `"a b"`, `"1"x`, `w/"1"`} {
+		_, ok := ParseETag(value)
+		assert.False(t, ok, value)
+	}
+	assert.Equal(t, `W/"x"`, WeakETag("x").String())
+}
+
+func TestParseETagList(t *testing.T) {
+	tags, any, ok := parseETagList(`"a", W/"b" ,"c"`)
+	require.True(t, ok)
+	assert.False(t, any)
+	assert.Equal(t, []ETag{StrongETag("a"), WeakETag("b"), StrongETag("c")}, tags)
+
+	_, any, ok = parseETagList(" * ")
+	assert.True(t, ok && any)
+
+	_, _, ok = parseETagList(`"a" "b"`)
+	assert.False(t, ok)
+}
+
+var modified = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func evaluate(t *testing.T, method, fields string, v Validators) Outcome {
+	req, err := request.RequestHeadersFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: x\r\n" + fields + "\r\n"))
+	require.NoError(t, err)
+	return Evaluate(req, v)
+}
+
+func TestEvaluate(t *testing.T) {
+	v := Validators{ETag: StrongETag("v2"), LastModified: modified.Add(300 * time.Millisecond)}
+	before := FormatHTTPDate(modified.Add(-time.Hour))
+	same := FormatHTTPDate(modified)
+
+	for _, tc := range []struct {
+		name, method, fields string
+		want                 response.StatusCode
+	}{
+		{"no preconditions", "GET", "", ""},
+		{"if-match hit", "PUT", "If-Match: \"v1\", \"v2\"\r\n", ""},
+		{"if-match miss", "PUT", "If-Match: \"v1\"\r\n", response.StatusPreconditionFailed},
+		{"if-match is strong", "PUT", "If-Match: W/\"v2\"\r\n", response.StatusPreconditionFailed},
+		{"if-match star", "PUT", "If-Match: *\r\n", ""},
+		{"if-unmodified-since passed", "PUT", "If-Unmodified-Since: " + same + "\r\n", ""},
+		{"if-unmodified-since failed", "PUT", "If-Unmodified-Since: " + before + "\r\n", response.StatusPreconditionFailed},
+		// If-Match takes over from If-Unmodified-Since
+		{"if-match wins", "PUT", "If-Match: \"v2\"\r\nIf-Unmodified-Since: " + before + "\r\n", ""},
+		{"if-none-match hit on GET", "GET", "If-None-Match: W/\"v2\"\r\n", response.StatusNotModified},
+		{"if-none-match hit on PUT", "PUT", "If-None-Match: *\r\n", response.StatusPreconditionFailed},
+		{"if-none-match miss", "GET", "If-None-Match: \"v1\"\r\n", ""},
+		{"if-modified-since not modified", "GET", "If-Modified-Since: " + same + "\r\n", response.StatusNotModified},
+		{"if-modified-since modified", "GET", "If-Modified-Since: " + before + "\r\n", ""},
+		{"if-modified-since ignored on POST", "POST", "If-Modified-Since: " + same + "\r\n", ""},
+		// If-None-Match takes over from If-Modified-Since
+		{"if-none-match wins", "GET", "If-None-Match: \"v1\"\r\nIf-Modified-Since: " + same + "\r\n", ""},
+		{"bad date ignored", "GET", "If-Modified-Since: yesterday\r\n", ""},
+		{"if-match before if-none-match", "GET", "If-Match: \"v1\"\r\nIf-None-Match: \"v2\"\r\n", response.StatusPreconditionFailed},
+	} {
+		assert.Equal(t, tc.want, evaluate(t, tc.method, tc.fields, v).Status, tc.name)
+	}
+}
+
+func TestEvaluateMissing(t *testing.T) {
+	// creating a resource only if it does not exist yet
+	missing := Validators{Missing: true}
+	assert.Equal(t, response.StatusCode(""), evaluate(t, "PUT", "If-None-Match: *\r\n", missing).Status)
+	assert.Equal(t, response.StatusPreconditionFailed, evaluate(t, "PUT", "If-Match: *\r\n", missing).Status)
+}
+
+func TestEvaluateIfRange(t *testing.T) {
+	v := Validators{ETag: StrongETag("v2"), LastModified: modified}
+	weak := Validators{ETag: WeakETag("v2"), LastModified: modified}
+
+	assert.False(t, evaluate(t, "GET", "", v).HonorRange)
+	assert.True(t, evaluate(t, "GET", "Range: bytes=0-9\r\n", v).HonorRange)
+	assert.False(t, evaluate(t, "POST", "Range: bytes=0-9\r\n", v).HonorRange)
+	assert.True(t, evaluate(t, "GET", "Range: bytes=0-9\r\nIf-Range: \"v2\"\r\n", v).HonorRange)
+	assert.False(t, evaluate(t, "GET", "Range: bytes=0-9\r\nIf-Range: \"v1\"\r\n", v).HonorRange)
+	assert.False(t, evaluate(t, "GET", "Range: bytes=0-9\r\nIf-Range: W/\"v2\"\r\n", weak).HonorRange)
+	assert.True(t, evaluate(t, "GET", "Range: bytes=0-9\r\nIf-Range: "+FormatHTTPDate(modified)+"\r\n", v).HonorRange)
+	assert.False(t, evaluate(t, "GET", "Range: bytes=0-9\r\nIf-Range: "+FormatHTTPDate(modified.Add(-time.Second))+"\r\n", v).HonorRange)
+}
+
+// check runs Check on a connection and returns what the client got.
+func check(t *testing.T, method, fields string, v Validators) (bool, *http.Response) {
+	req, err := request.RequestHeadersFromReader(strings.NewReader(method + " / HTTP/1.1\r\nHost: x\r\n" + fields + "\r\n"))
+	require.NoError(t, err)
+
+	result := make(chan bool, 1)
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer serverSide.Close()
+		w := response.NewWriter(serverSide, nil)
+		_, handled, _ := Check(w, req, v)
+		result <- handled
+		if !handled {
+			w.WriteStatusLine(response.StatusOk)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		}
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	return <-result, resp
+}
+
+func TestCheck(t *testing.T) {
+	v := Validators{ETag: StrongETag("v2"), LastModified: modified}
+
+	handled, resp := check(t, "GET", "If-None-Match: \"v2\"\r\n", v)
+	assert.True(t, handled)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, `"v2"`, resp.Header.Get("ETag"))
+	assert.Equal(t, FormatHTTPDate(modified), resp.Header.Get("Last-Modified"))
+
+	handled, resp = check(t, "PUT", "If-Match: \"v1\"\r\n", v)
+	assert.True(t, handled)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("Content-Length"))
+
+	handled, resp = check(t, "GET", "If-None-Match: \"v1\"\r\n", v)
+	assert.False(t, handled)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/oliverTuesta/http-tcp/internal/tracing"
+)
+
+var ERROR_BAD_PROXY_TARGET = fmt.Errorf("bad proxy target")
+var ERROR_DESTINATION_DENIED = fmt.Errorf("proxy destination denied")
+
+type Config struct {
+	// Allow and Deny hold destination patterns: a host ("example.com"), a
+	// wildcard suffix ("*.example.com"), an IP address or CIDR block
+	// ("10.0.0.0/8"), or any of those with a port ("example.com:443").
+	// Deny wins; an empty Allow permits everything. Deny is checked again
+	// against each address the proxy dials, so a name resolving to a
+	// denied address is refused too.
+	Allow []string
+	Deny  []string
+
+	// Credentials maps usernames to passwords for Proxy-Authorization.
+	// Empty means no authentication is required.
+	Credentials map[string]string
+
+	DialTimeout time.Duration
+	// IdleTimeout closes a tunnel once neither side has sent anything for
+	// this long, and a forwarded request once its upstream has not. Zero
+	// disables it.
+	IdleTimeout time.Duration
+}
+
+type Proxy struct {
+	cfg Config
+}
+
+func New(cfg Config) *Proxy {
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = 10 * time.Second
+	}
+	return &Proxy{cfg: cfg}
+}
+
+// hop-by-hop headers are meaningful only for a single connection and must
+// not be forwarded (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{
+	"connection",
+	"proxy-connection",
+	"keep-alive",
+	"proxy-authorization",
+	"proxy-authenticate",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// Handler serves CONNECT and absolute-form requests as a forward proxy and
+// passes every other request to next.
+func (p *Proxy) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		switch {
+		case req.RequestLine.Method == "CONNECT":
+		case strings.HasPrefix(req.RequestLine.RequestTarget, "http://"):
+		default:
+			return next(w, req)
+		}
+
+		if !p.authorized(req) {
+			h := response.GetDefaultHeaders(0)
+			h.Add("proxy-authenticate", `Basic realm="proxy"`)
+			writeResponse(w, response.StatusProxyAuthRequired, h)
+			return nil
+		}
+
+		if req.RequestLine.Method == "CONNECT" {
+			return p.tunnel(w, req)
+		}
+		return p.forward(w, req)
+	}
+}
+
+func (p *Proxy) tunnel(w *response.Writer, req *request.Request) *server.HandlerError {
+	host, port, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+	if err != nil || host == "" || port == "" {
+		return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: ERROR_BAD_PROXY_TARGET.Error()}
+	}
+	if !p.Allowed(host, port) {
+		return &server.HandlerError{StatusCode: response.StatusForbidden}
+	}
+
+	upstream, err := p.dial(host, port)
+	if err != nil {
+		return dialError(err)
+	}
+
+	h := headers.NewHeaders()
+	if err := writeResponse(w, response.StatusOk, h); err != nil {
+		upstream.Close()
+		return nil
+	}
+
+	client, buffered, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		return nil
+	}
+	if len(buffered) > 0 {
+		if _, err := upstream.Write(buffered); err != nil {
+			upstream.Close()
+			client.Close()
+			return nil
+		}
+	}
+
+	go splice(client, upstream, p.cfg.IdleTimeout)
+	return nil
+}
+
+func (p *Proxy) forward(w *response.Writer, req *request.Request) *server.HandlerError {
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || target.Host == "" {
+		return &server.HandlerError{StatusCode: response.StatusBadRequest, Message: ERROR_BAD_PROXY_TARGET.Error()}
+	}
+
+	port := target.Port()
+	if port == "" {
+		port = "80"
+	}
+	if !p.Allowed(target.Hostname(), port) {
+		return &server.HandlerError{StatusCode: response.StatusForbidden}
+	}
+
+	if err := req.ReadBody(); err != nil {
+		return server.NewHandlerError(err)
+	}
+
+	upstream, err := p.dial(target.Hostname(), port)
+	if err != nil {
+		return dialError(err)
+	}
+
+	if err := writeOriginRequest(upstream, req, target); err != nil {
+		upstream.Close()
+		return &server.HandlerError{StatusCode: response.StatusBadGateway, Message: err.Error()}
+	}
+
+	client, _, err := w.Hijack()
+	if err != nil {
+		upstream.Close()
+		return nil
+	}
+
+	// the upstream request asked for "connection: close", so the response
+	// ends when upstream closes its side
+	go func() {
+		defer client.Close()
+		defer upstream.Close()
+		copyUntilIdle(client, upstream, p.cfg.IdleTimeout)
+	}()
+	return nil
+}
+
+// copyUntilIdle copies src to dst until src closes, or until it has sent
+// nothing for idleTimeout.
+func copyUntilIdle(dst io.Writer, src net.Conn, idleTimeout time.Duration) {
+	buf := make([]byte, 32*1024)
+	for {
+		if idleTimeout > 0 {
+			src.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeOriginRequest(w io.Writer, req *request.Request, target *url.URL) error {
+	requestTarget := target.RequestURI()
+	_, err := fmt.Fprintf(w, "%s %s HTTP/%s\r\n", req.RequestLine.Method, requestTarget, req.RequestLine.HttpVersion)
+	if err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+	for key, value := range req.Headers {
+		h[key] = value
+	}
+	if connection, ok := req.Headers.Get("connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			delete(h, strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		delete(h, name)
+	}
+	h.Add("host", target.Host)
+	h.Add("connection", "close")
+	tracing.Inject(req.Context(), h)
+
+	if err := response.WriteHeaders(w, h); err != nil {
+		return err
+	}
+
+	_, err = w.Write(req.Body)
+	return err
+}
+
+func (p *Proxy) authorized(req *request.Request) bool {
+	if len(p.cfg.Credentials) == 0 {
+		return true
+	}
+
+	auth, ok := req.Headers.Get("proxy-authorization")
+	if !ok {
+		return false
+	}
+	scheme, encoded, ok := strings.Cut(strings.TrimSpace(auth), " ")
+	if !ok || !strings.EqualFold(scheme, "basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+
+	expected, ok := p.cfg.Credentials[user]
+	return ok && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// Allowed reports whether the proxy may connect to host:port.
+func (p *Proxy) Allowed(host, port string) bool {
+	for _, pattern := range p.cfg.Deny {
+		if matchDestination(pattern, host, port) {
+			return false
+		}
+	}
+	if len(p.cfg.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.cfg.Allow {
+		if matchDestination(pattern, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// dial connects to host:port, refusing addresses on the deny list once
+// host is resolved.
+func (p *Proxy) dial(host, port string) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout: p.cfg.DialTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			ip, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			for _, pattern := range p.cfg.Deny {
+				if matchDestination(pattern, ip, port) {
+					return ERROR_DESTINATION_DENIED
+				}
+			}
+			return nil
+		},
+	}
+	return dialer.Dial("tcp", net.JoinHostPort(host, port))
+}
+
+func dialError(err error) *server.HandlerError {
+	if errors.Is(err, ERROR_DESTINATION_DENIED) {
+		return &server.HandlerError{StatusCode: response.StatusForbidden}
+	}
+	log.Println("proxy dial error:", err)
+	return &server.HandlerError{StatusCode: response.StatusBadGateway, Message: err.Error()}
+}
+
+func matchDestination(pattern, host, port string) bool {
+	patternHost, patternPort, err := net.SplitHostPort(pattern)
+	if err != nil {
+		patternHost, patternPort = pattern, ""
+	}
+	if patternPort != "" && patternPort != port {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if _, block, err := net.ParseCIDR(patternHost); err == nil {
+			return block.Contains(ip)
+		}
+		if patternIP := net.ParseIP(patternHost); patternIP != nil {
+			return patternIP.Equal(ip)
+		}
+	}
+
+	matched, err := path.Match(strings.ToLower(patternHost), strings.ToLower(host))
+	return err == nil && matched
+}
+
+func writeResponse(w *response.Writer, statusCode response.StatusCode, h headers.Headers) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	return w.WriteHeaders(h)
+}
+
+// splice copies bytes both ways until either side closes, or until the
+// tunnel has been idle in both directions for idleTimeout.
+func splice(a, b net.Conn, idleTimeout time.Duration) {
+	defer a.Close()
+	defer b.Close()
+
+	var lastActivity atomic.Int64
+	lastActivity.Store(time.Now().UnixNano())
+
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		defer func() { done <- struct{}{} }()
+		buf := make([]byte, 32*1024)
+		for {
+			if idleTimeout > 0 {
+				src.SetReadDeadline(time.Now().Add(idleTimeout))
+			}
+			n, err := src.Read(buf)
+			if n > 0 {
+				lastActivity.Store(time.Now().UnixNano())
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					idle := time.Since(time.Unix(0, lastActivity.Load()))
+					if idle < idleTimeout {
+						continue
+					}
+				}
+				if tcp, ok := dst.(interface{ CloseWrite() error }); ok {
+					tcp.CloseWrite()
+				}
+				return
+			}
+		}
+	}
+
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	<-done
+	<-done
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/oliverTuesta/http-tcp/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowed(t *testing.T) {
+	p := New(Config{
+		Allow: []string{"*.example.com", "localhost:8080"},
+		Deny:  []string{"internal.example.com"},
+	})
+
+	assert.True(t, p.Allowed("api.example.com", "443"))
+	assert.True(t, p.Allowed("localhost", "8080"))
+	assert.False(t, p.Allowed("localhost", "22"))
+	assert.False(t, p.Allowed("internal.example.com", "443"))
+	assert.False(t, p.Allowed("example.org", "443"))
+
+	open := New(Config{Deny: []string{"*:25"}})
+	assert.True(t, open.Allowed("example.org", "443"))
+	assert.False(t, open.Allowed("mail.example.org", "25"))
+
+	blocks := New(Config{Deny: []string{"10.0.0.0/8", "::1", "192.168.1.1:22"}})
+	assert.False(t, blocks.Allowed("10.1.2.3", "80"))
+	assert.False(t, blocks.Allowed("0:0::1", "80"))
+	assert.False(t, blocks.Allowed("192.168.1.1", "22"))
+	assert.True(t, blocks.Allowed("192.168.1.1", "80"))
+	assert.True(t, blocks.Allowed("11.0.0.1", "80"))
+}
+
+func TestDialChecksResolvedAddress(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// the name passes the deny list, the address it resolves to does not
+	p := New(Config{Deny: []string{"127.0.0.0/8", "::1"}})
+	assert.True(t, p.Allowed("localhost", port))
+	_, err = p.dial("localhost", port)
+	assert.ErrorIs(t, err, ERROR_DESTINATION_DENIED)
+
+	req := parse(t, "CONNECT localhost:"+port+" HTTP/1.1\r\nHost: localhost:"+port+"\r\n\r\n")
+	handlerErr := p.Handler(notFound)(response.NewWriter(nil, nil), req)
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, response.StatusForbidden, handlerErr.StatusCode)
+
+	conn, err := New(Config{}).dial("localhost", port)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func parse(t *testing.T, raw string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func notFound(w *response.Writer, req *request.Request) *server.HandlerError {
+	return &server.HandlerError{StatusCode: response.StatusNotFound}
+}
+
+func TestAuthorization(t *testing.T) {
+	p := New(Config{Credentials: map[string]string{"alice": "secret"}})
+	good := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	bad := base64.StdEncoding.EncodeToString([]byte("alice:wrong"))
+
+	assert.True(t, p.authorized(parse(t, "CONNECT a:443 HTTP/1.1\r\nProxy-Authorization: Basic "+good+"\r\n\r\n")))
+	assert.False(t, p.authorized(parse(t, "CONNECT a:443 HTTP/1.1\r\nProxy-Authorization: Basic "+bad+"\r\n\r\n")))
+	assert.False(t, p.authorized(parse(t, "CONNECT a:443 HTTP/1.1\r\n\r\n")))
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		w := response.NewWriter(serverSide, nil)
+		p.Handler(notFound)(w, parse(t, "CONNECT a:443 HTTP/1.1\r\n\r\n"))
+		serverSide.Close()
+	}()
+	reply, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(reply), "HTTP/1.1 407"))
+	assert.Contains(t, string(reply), `proxy-authenticate: Basic realm="proxy"`)
+}
+
+func TestConnectTunnel(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	p := New(Config{IdleTimeout: time.Second})
+	req := parse(t, "CONNECT "+echo.Addr().String()+" HTTP/1.1\r\nHost: "+echo.Addr().String()+"\r\n\r\n")
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		w := response.NewWriter(serverSide, []byte("early "))
+		handlerErr := p.Handler(notFound)(w, req)
+		assert.Nil(t, handlerErr)
+		assert.True(t, w.Hijacked())
+	}()
+
+	reader := bufio.NewReader(client)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", statusLine)
+	blank, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", blank)
+
+	_, err = client.Write([]byte("bytes"))
+	require.NoError(t, err)
+	echoed := make([]byte, len("early bytes"))
+	_, err = io.ReadFull(reader, echoed)
+	require.NoError(t, err)
+	assert.Equal(t, "early bytes", string(echoed))
+}
+
+func TestForwardIdleTimeout(t *testing.T) {
+	stalled, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stalled.Close()
+	go func() {
+		conn, err := stalled.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// start the response, then never finish it
+		conn.Write([]byte("HTTP/1.1 200 OK\r\n"))
+		time.Sleep(5 * time.Second)
+	}()
+
+	p := New(Config{IdleTimeout: 50 * time.Millisecond})
+	req := parse(t, "GET http://"+stalled.Addr().String()+"/a HTTP/1.1\r\nHost: "+stalled.Addr().String()+"\r\n\r\n")
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go p.Handler(notFound)(response.NewWriter(serverSide, nil), req)
+
+	// the stalled upstream does not hold the client's connection open
+	done := make(chan []byte)
+	go func() {
+		reply, _ := io.ReadAll(client)
+		done <- reply
+	}()
+	select {
+	case reply := <-done:
+		assert.Equal(t, "HTTP/1.1 200 OK\r\n", string(reply))
+	case <-time.After(2 * time.Second):
+		t.Fatal("forwarded connection outlived the idle timeout")
+	}
+}
+
+func TestNonProxyRequestPassesThrough(t *testing.T) {
+	p := New(Config{})
+	handlerErr := p.Handler(notFound)(response.NewWriter(nil, nil), parse(t, "GET /local HTTP/1.1\r\n\r\n"))
+	require.NotNil(t, handlerErr)
+	assert.Equal(t, response.StatusNotFound, handlerErr.StatusCode)
+}
+
+func TestOriginRequestCarriesTrace(t *testing.T) {
+	req := parse(t, "GET http://example.com/a HTTP/1.1\r\nHost: example.com\r\n"+
+		"Traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01\r\n\r\n")
+	tc, err := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01")
+	require.NoError(t, err)
+	req = req.WithContext(tracing.NewContext(req.Context(), tc))
+
+	target, err := url.Parse(req.RequestLine.RequestTarget)
+	require.NoError(t, err)
+	var out strings.Builder
+	require.NoError(t, writeOriginRequest(&out, req, target))
+
+	// the upstream sees this proxy's span as its parent, not the client's
+	assert.Contains(t, out.String(), "traceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01\r\n")
+}
@@ -0,0 +1,146 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+// sweepEvery is how many requests pass between sweeps of idle buckets.
+const sweepEvery = 1024
+
+type Config struct {
+	// Rate is how many requests per second a client may make on average,
+	// and Burst how many it may make at once.
+	Rate  float64
+	Burst int
+
+	// Key picks the bucket a request counts against. The default is the
+	// client's IP address.
+	Key func(req *request.Request) string
+
+	// Now is the clock; tests swap it for a fake one.
+	Now func() time.Time
+}
+
+// Limiter is a token bucket per key. Each bucket holds up to Burst tokens,
+// refills at Rate tokens a second, and every request takes one.
+type Limiter struct {
+	cfg Config
+
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	requests int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	if cfg.Key == nil {
+		cfg.Key = RemoteIP
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Limiter{cfg: cfg, buckets: map[string]*bucket{}}
+}
+
+// RemoteIP keys requests by the IP address of the client.
+func RemoteIP(req *request.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// Allow takes a token from key's bucket. It reports whether one was
+// available, how many are left, and how long until the bucket is full
+// again or, when refused, until the next token.
+func (l *Limiter) Allow(key string) (ok bool, remaining int, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.cfg.Now()
+	l.requests++
+	if l.requests%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, 0, l.duration(1 - b.tokens)
+	}
+	b.tokens--
+	return true, int(b.tokens), l.duration(float64(l.cfg.Burst) - b.tokens)
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	elapsed := now.Sub(b.last).Seconds()
+	return math.Min(float64(l.cfg.Burst), b.tokens+elapsed*l.cfg.Rate)
+}
+
+// duration is how long the bucket takes to gain tokens.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	if l.cfg.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / l.cfg.Rate * float64(time.Second))
+}
+
+// sweep forgets buckets that have refilled, since a new bucket starts full
+// anyway.
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.cfg.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Handler answers 429 Too Many Requests, with Retry-After and the
+// RateLimit-* fields of the IETF httpapi draft, to requests over the limit
+// and passes the rest to next.
+func (l *Limiter) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		ok, remaining, wait := l.Allow(l.cfg.Key(req))
+		if ok {
+			return next(w, req)
+		}
+
+		h := response.GetDefaultHeaders(0)
+		h.Add("retry-after", strconv.Itoa(seconds(wait)))
+		h.Add("ratelimit-limit", strconv.Itoa(l.cfg.Burst))
+		h.Add("ratelimit-remaining", strconv.Itoa(remaining))
+		h.Add("ratelimit-reset", strconv.Itoa(seconds(wait)))
+		if err := w.WriteStatusLine(response.StatusTooManyRequests); err != nil {
+			return nil
+		}
+		w.WriteHeaders(h)
+		return nil
+	}
+}
+
+// seconds rounds up, so a client waiting that long is never early.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestAllow(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := New(Config{Rate: 2, Burst: 3, Now: clock.Now})
+
+	for want := 2; want >= 0; want-- {
+		ok, remaining, _ := l.Allow("a")
+		require.True(t, ok)
+		assert.Equal(t, want, remaining)
+	}
+
+	ok, _, wait := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// other clients have their own bucket
+	ok, _, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	clock.Advance(500 * time.Millisecond)
+	ok, remaining, reset := l.Allow("a")
+	assert.True(t, ok)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, 1500*time.Millisecond, reset)
+
+	// a long pause refills the bucket to Burst, not beyond
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		ok, _, _ = l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, _, _ = l.Allow("a")
+	assert.False(t, ok)
+}
+
+func TestHandler(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	l := New(Config{Rate: 0.5, Burst: 1, Now: clock.Now})
+	handler := l.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return nil
+	})
+
+	serve := func() *http.Response {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+		require.NoError(t, err)
+		req.RemoteAddr = "192.0.2.1:50000"
+
+		serverSide, client := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer serverSide.Close()
+			w := response.NewWriter(serverSide, nil)
+			if handler(w, req) == nil && !w.StatusWritten() {
+				w.WriteStatusLine(response.StatusOk)
+				w.WriteHeaders(response.GetDefaultHeaders(0))
+			}
+		}()
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		require.NoError(t, err)
+		return resp
+	}
+
+	assert.Equal(t, http.StatusOK, serve().StatusCode)
+
+	resp := serve()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Reset"))
+
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, http.StatusOK, serve().StatusCode)
+}
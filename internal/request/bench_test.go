@@ -0,0 +1,62 @@
+package request
+
+import (
+	"strings"
+	"testing"
+)
+
+var benchRequest = "GET /api/v1/items?limit=20 HTTP/1.1\r\n" +
+	"Host: api.example.com\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36\r\n" +
+	"Accept: application/json\r\n" +
+	"Accept-Encoding: gzip, deflate, br\r\n" +
+	"Accept-Language: en-US,en;q=0.9\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Cookie: session=abc123; theme=dark\r\n" +
+	"\r\n"
+
+var benchPostRequest = "POST /api/v1/items HTTP/1.1\r\n" +
+	"Host: api.example.com\r\n" +
+	"Content-Type: application/json\r\n" +
+	"Content-Length: 27\r\n" +
+	"\r\n" +
+	`{"name":"widget","qty":100}`
+
+var benchRequests = []struct{ name, raw string }{
+	{"get", benchRequest},
+	{"post", benchPostRequest},
+}
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	for _, bench := range benchRequests {
+		b.Run(bench.name, func(b *testing.B) {
+			reader := strings.NewReader(bench.raw)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bench.raw)))
+			for i := 0; i < b.N; i++ {
+				reader.Reset(bench.raw)
+				if _, err := RequestFromReader(reader); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRequestFromReaderPooled(b *testing.B) {
+	for _, bench := range benchRequests {
+		b.Run(bench.name, func(b *testing.B) {
+			reader := strings.NewReader(bench.raw)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(bench.raw)))
+			for i := 0; i < b.N; i++ {
+				reader.Reset(bench.raw)
+				r, err := RequestFromReaderPooled(reader)
+				if err != nil {
+					b.Fatal(err)
+				}
+				r.Release()
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package request
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ERROR_UNSUPPORTED_CONTENT_ENCODING = fmt.Errorf("unsupported content encoding")
+var ERROR_MALFORMED_ENCODED_BODY = fmt.Errorf("malformed encoded body")
+var ERROR_DECODED_BODY_TOO_LARGE = fmt.Errorf("decoded body larger than limit")
+
+// DecodeBody replaces a gzip or deflate encoded body with its decoded
+// bytes, for this request and its copies, refusing to produce more than
+// maxSize bytes. Codings listed in
+// Content-Encoding are undone in reverse order of application.
+func (r *Request) DecodeBody(maxSize int) error {
+	encoding, ok := r.Headers.Get("content-encoding")
+	if !ok {
+		return nil
+	}
+
+	codings := strings.Split(encoding, ",")
+	body := r.body
+
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(codings[i])) {
+		case "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = decodeGzip(body, maxSize)
+		case "deflate":
+			body, err = decodeDeflate(body, maxSize)
+		default:
+			return ERROR_UNSUPPORTED_CONTENT_ENCODING
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	r.body = body
+	r.Body = body
+	delete(r.Headers, "content-encoding")
+	r.Headers.Add("content-length", strconv.Itoa(len(body)))
+
+	return nil
+}
+
+func decodeGzip(body []byte, maxSize int) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Join(ERROR_MALFORMED_ENCODED_BODY, err)
+	}
+	defer reader.Close()
+
+	return readLimited(reader, maxSize)
+}
+
+// "deflate" is specified as zlib-wrapped data, but some clients send a raw
+// deflate stream, so fall back to that when the zlib header is missing.
+func decodeDeflate(body []byte, maxSize int) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		raw := flate.NewReader(bytes.NewReader(body))
+		defer raw.Close()
+		return readLimited(raw, maxSize)
+	}
+	defer reader.Close()
+
+	return readLimited(reader, maxSize)
+}
+
+func readLimited(reader io.Reader, maxSize int) ([]byte, error) {
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, errors.Join(ERROR_MALFORMED_ENCODED_BODY, err)
+	}
+
+	if len(decoded) > maxSize {
+		return nil, ERROR_DECODED_BODY_TOO_LARGE
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,68 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const snippetLen = 32
+
+// ParseError describes where a request stopped parsing. Err is one of the
+// package sentinels (or a headers package one), so errors.Is keeps working.
+type ParseError struct {
+	State ParserState
+	// Offset counts bytes from the start of the request.
+	Offset  int
+	Snippet string
+	// Status is the HTTP status code to answer with: 400 Bad Request, or
+	// 413, 414, 431, 501 or 505 for the errors that have their own.
+	Status int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v (state %s, offset %d, near %q)", e.Err, e.State, e.Offset, e.Snippet)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+func (r *Request) parseError(err error, at int) *ParseError {
+	snippet := r.buf[r.start+at : r.bufLen]
+	if idx := bytes.IndexByte(snippet, '\n'); idx != -1 {
+		snippet = snippet[:idx+1]
+	}
+	if len(snippet) > snippetLen {
+		snippet = snippet[:snippetLen]
+	}
+
+	return &ParseError{
+		State:   r.state,
+		Offset:  r.consumed + at,
+		Snippet: string(snippet),
+		Status:  statusForParseError(err, r.state),
+		Err:     err,
+	}
+}
+
+func statusForParseError(err error, state ParserState) int {
+	switch {
+	case errors.Is(err, ERROR_UNSUPPORTED_HTTP_VERSION):
+		return 505
+	case errors.Is(err, ERROR_HEADERS_TOO_LARGE):
+		// cut off before the request line ended
+		if state == StateInit {
+			return 414
+		}
+		return 431
+	case errors.Is(err, ERROR_BODY_TOO_LARGE):
+		return 413
+	case errors.Is(err, ERROR_UNSUPPORTED_HTTP_METHOD),
+		errors.Is(err, ERROR_UNSUPPORTED_TRANSFER_ENCODING):
+		return 501
+	default:
+		return 400
+	}
+}
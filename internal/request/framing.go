@@ -0,0 +1,112 @@
+package request
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+)
+
+var ERROR_INVALID_CONTENT_LENGTH = fmt.Errorf("invalid content-length")
+var ERROR_CONFLICTING_CONTENT_LENGTH = fmt.Errorf("conflicting content-length values")
+var ERROR_CONTENT_LENGTH_WITH_TRANSFER_ENCODING = fmt.Errorf("both content-length and transfer-encoding present")
+var ERROR_CHUNKED_NOT_FINAL = fmt.Errorf("chunked is not the final transfer coding")
+var ERROR_UNSUPPORTED_TRANSFER_ENCODING = fmt.Errorf("unsupported transfer coding")
+var ERROR_BAD_CHUNK_SIZE = fmt.Errorf("bad chunk size")
+var ERROR_BAD_CHUNK = fmt.Errorf("chunk data not terminated by CRLF")
+
+// maxChunkSize bounds a single chunk so a hostile size line cannot make us
+// buffer without limit or overflow.
+const maxChunkSize = 1 << 30
+
+// bodyFraming applies the message body length rules of RFC 9112 section
+// 6.3. It reports either a chunked body or a length, which is -1 when the
+// request has no body.
+func (r *Request) bodyFraming() (chunked bool, length int, err error) {
+	transferEncoding, hasTE := r.Headers.Get("transfer-encoding")
+	contentLength, hasCL := r.Headers.Get("content-length")
+
+	// a request carrying both is the classic smuggling vector: an
+	// intermediary may frame it by one and us by the other
+	if hasTE && hasCL {
+		return false, 0, ERROR_CONTENT_LENGTH_WITH_TRANSFER_ENCODING
+	}
+
+	if hasTE {
+		codings := strings.Split(transferEncoding, ",")
+		if !strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			return false, 0, ERROR_CHUNKED_NOT_FINAL
+		}
+		if len(codings) > 1 {
+			return false, 0, ERROR_UNSUPPORTED_TRANSFER_ENCODING
+		}
+		return true, 0, nil
+	}
+
+	if hasCL {
+		length, err := parseContentLength(contentLength)
+		if err != nil {
+			return false, 0, err
+		}
+		// collapse identical repeated values into the one they agree on
+		if strings.IndexByte(contentLength, ',') != -1 {
+			r.Headers.Add("content-length", strconv.Itoa(length))
+		}
+		return false, length, nil
+	}
+
+	return false, -1, nil
+}
+
+// parseContentLength accepts a list of identical values, which is what
+// Headers.Parse makes of a repeated content-length field.
+func parseContentLength(value string) (int, error) {
+	length := -1
+	for more := true; more; {
+		var v string
+		v, value, more = strings.Cut(value, ",")
+		v = strings.TrimSpace(v)
+		if v == "" || strings.Trim(v, "0123456789") != "" {
+			return 0, ERROR_INVALID_CONTENT_LENGTH
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, ERROR_INVALID_CONTENT_LENGTH
+		}
+		if length != -1 && n != length {
+			return 0, ERROR_CONFLICTING_CONTENT_LENGTH
+		}
+		length = n
+	}
+	return length, nil
+}
+
+// parseChunkSize parses a chunk-size line, ignoring chunk extensions. It
+// returns n == 0 until the whole line is buffered.
+func parseChunkSize(data []byte) (size int, n int, err error) {
+	line, n, err := headers.NextLine(data)
+	if err != nil || n == 0 {
+		return 0, 0, err
+	}
+
+	hex, _, _ := strings.Cut(string(line), ";")
+	hex = strings.TrimRight(hex, " \t")
+	if hex == "" || len(hex) > 8 || strings.Trim(strings.ToLower(hex), "0123456789abcdef") != "" {
+		return 0, 0, ERROR_BAD_CHUNK_SIZE
+	}
+
+	parsed, err := strconv.ParseInt(hex, 16, 64)
+	if err != nil || parsed > maxChunkSize {
+		return 0, 0, ERROR_BAD_CHUNK_SIZE
+	}
+
+	return int(parsed), n, nil
+}
+
+// finishChunkedBody reframes a decoded chunked body by length, so handlers
+// and anything forwarding the request see a plain body.
+func (r *Request) finishChunkedBody() {
+	delete(r.Headers, "transfer-encoding")
+	r.Headers.Add("content-length", strconv.Itoa(r.bodySize))
+}
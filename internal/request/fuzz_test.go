@@ -0,0 +1,153 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedRequests are drawn from the examples in RFC 9110 and RFC 9112, plus
+// the shapes the parser has special cases for.
+var seedRequests = []string{
+	"GET /hello.txt HTTP/1.1\r\nUser-Agent: curl/7.64.1\r\nHost: www.example.com\r\nAccept-Language: en, mi\r\n\r\n",
+	"GET /where?q=now HTTP/1.1\r\nHost: www.example.org\r\n\r\n",
+	"GET http://www.example.org/pub/WWW/TheProject.html HTTP/1.1\r\nHost: www.example.org\r\n\r\n",
+	"CONNECT server.example.com:80 HTTP/1.1\r\nHost: server.example.com\r\n\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"PUT /new.txt HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 0\r\n\r\n",
+	"POST /upload HTTP/1.1\r\nHost: example.com\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nWiki\r\n5;ext=1\r\npedia\r\n0\r\nExpires: never\r\n\r\n",
+	"PATCH /file.txt HTTP/1.1\r\nHost: www.example.com\r\nContent-Type: application/example\r\nIf-Match: \"e0023aa4e\"\r\nContent-Length: 10\r\n\r\ncdefghijkl",
+	"GET / HTTP/1.1\r\nHost: a\r\nCache-Control: no-cache\r\nCache-Control: max-age=0\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+	"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG",
+	"GET / HTTP/1.1\r\nHost: a\r\nX-Folded: one\r\n two\r\n\r\n",
+	"GET / HTTP/1.1\nHost: a\n\n",
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	for _, seed := range seedRequests {
+		f.Add([]byte(seed), 3)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, chunk int) {
+		// keep reads short so the chunked-reader paths are exercised
+		if chunk <= 0 || chunk > len(data) {
+			chunk = len(data)%16 + 1
+		}
+		r, err := RequestFromReader(&chunkReader{data: string(data), numBytesPerRead: chunk})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, "1.1", r.RequestLine.HttpVersion)
+		for key := range r.Headers {
+			assert.Equal(t, strings.ToLower(key), key)
+		}
+		if contentLength, ok := r.Headers.Get("content-length"); ok {
+			n, err := strconv.Atoi(contentLength)
+			require.NoError(t, err)
+			assert.Len(t, r.Body, n)
+		}
+	})
+}
+
+func FuzzParseRequestLine(f *testing.F) {
+	for _, seed := range seedRequests {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rl, n, err := parseRequestLine(data, false)
+		if err != nil || n == 0 {
+			assert.Zero(t, rl)
+			assert.Zero(t, n)
+			return
+		}
+
+		view, _, err := parseRequestLine(bytes.Clone(data), true)
+		require.NoError(t, err)
+		assert.Equal(t, rl, view)
+		require.LessOrEqual(t, n, len(data))
+		assert.Equal(t, "\r\n", string(data[n-2:n]))
+		assert.NotContains(t, rl.RequestTarget, " ")
+	})
+}
+
+// TestDifferentialNetHTTP runs the seed corpus through both parsers.
+func TestDifferentialNetHTTP(t *testing.T) {
+	for _, seed := range seedRequests {
+		compareWithNetHTTP(t, []byte(seed))
+	}
+}
+
+func FuzzDifferentialNetHTTP(f *testing.F) {
+	for _, seed := range seedRequests {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		compareWithNetHTTP(t, data)
+	})
+}
+
+// compareWithNetHTTP flags inputs we accept but net/http rejects, and
+// inputs both accept but read differently. Rejecting something net/http
+// accepts is fine: we are deliberately stricter about methods, versions,
+// empty field values and framing.
+func compareWithNetHTTP(t *testing.T, data []byte) {
+	t.Helper()
+
+	ours, ourErr := RequestFromReader(bytes.NewReader(data))
+	if ourErr != nil {
+		return
+	}
+
+	theirs, theirErr := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	var theirBody []byte
+	if theirErr == nil {
+		theirBody, theirErr = io.ReadAll(theirs.Body)
+	}
+	// repeated Host fields are combined like any other field, which
+	// TestRequestHeadersParse pins down
+	if theirErr != nil && strings.Contains(theirErr.Error(), "too many Host headers") {
+		return
+	}
+	if theirErr != nil {
+		t.Errorf("accepted a request net/http rejects (%v): %q", theirErr, data)
+		return
+	}
+
+	assert.Equal(t, theirs.Method, ours.RequestLine.Method, "method of %q", data)
+	assert.Equal(t, theirs.RequestURI, ours.RequestLine.RequestTarget, "target of %q", data)
+	assert.Equal(t, string(theirBody), string(ours.Body), "body of %q", data)
+
+	// net/http moves Host and the framing headers out of Header, and we
+	// rewrite framing after decoding a chunked body
+	ourHeaders := map[string]string{}
+	for key, value := range ours.Headers {
+		ourHeaders[key] = value
+	}
+	theirHeaders := map[string]string{}
+	for key, values := range theirs.Header {
+		theirHeaders[strings.ToLower(key)] = strings.Join(values, ", ")
+	}
+	// for absolute-form and authority-form targets net/http takes Host
+	// from the target instead of the header
+	if theirs.Host != "" && strings.HasPrefix(theirs.RequestURI, "/") {
+		theirHeaders["host"] = theirs.Host
+	} else {
+		delete(ourHeaders, "host")
+	}
+	for _, framing := range []string{"content-length", "transfer-encoding"} {
+		delete(theirHeaders, framing)
+		delete(ourHeaders, framing)
+	}
+	assert.Equal(t, theirHeaders, ourHeaders, "headers of %q", data)
+}
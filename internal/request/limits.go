@@ -0,0 +1,72 @@
+package request
+
+import (
+	"fmt"
+	"io"
+)
+
+var ERROR_HEADERS_TOO_LARGE = fmt.Errorf("request line and headers larger than limit")
+var ERROR_BODY_TOO_LARGE = fmt.Errorf("request body larger than limit")
+
+const DefaultMaxHeaderBytes = 1 << 20
+const DefaultMaxBodySize = 64 << 20
+
+// room left in the read buffer past the header and body limits for chunk
+// size lines and trailers
+const framingSlack = 4096
+
+// Limits bound what reading a request may buffer. Zero fields take the
+// defaults.
+type Limits struct {
+	// MaxHeaderBytes caps the request line and header fields together.
+	MaxHeaderBytes int
+	// MaxBodySize caps the body, however it is framed.
+	MaxBodySize int
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if l.MaxBodySize <= 0 {
+		l.MaxBodySize = DefaultMaxBodySize
+	}
+	return l
+}
+
+// maxBuffer is the most the read buffer may grow to.
+func (l Limits) maxBuffer() int {
+	return l.MaxHeaderBytes + l.MaxBodySize + framingSlack
+}
+
+// ReadHeaders is RequestHeadersFromReader within limits.
+func ReadHeaders(reader io.Reader, limits Limits) (*Request, error) {
+	request := NewRequest()
+	request.reader = reader
+	request.buf = make([]byte, 1024)
+	request.headersOnly = true
+	request.limits = limits.withDefaults()
+
+	err := request.readUntil(func() bool {
+		return request.state == StateParsingBody || request.done()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// inHead reports whether the request line and headers are still being
+// read.
+func (r *Request) inHead() bool {
+	return r.state == StateInit || r.state == StateParsingHeaders
+}
+
+// tooLarge is the error for a request that outgrew its buffer.
+func (r *Request) tooLarge() error {
+	if r.inHead() {
+		return ERROR_HEADERS_TOO_LARGE
+	}
+	return ERROR_BODY_TOO_LARGE
+}
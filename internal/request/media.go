@@ -0,0 +1,47 @@
+package request
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+var ERROR_MISSING_CONTENT_TYPE = fmt.Errorf("no content-type")
+var ERROR_BAD_CONTENT_TYPE = fmt.Errorf("malformed content-type")
+var ERROR_NOT_MULTIPART = fmt.Errorf("content-type is not multipart")
+var ERROR_BAD_BOUNDARY = fmt.Errorf("missing or invalid multipart boundary")
+
+// maxBoundaryLen is the RFC 2046 limit.
+const maxBoundaryLen = 70
+
+// ContentType returns the request's media type, lowercased, and its
+// parameters.
+func (r *Request) ContentType() (mediaType string, params map[string]string, err error) {
+	value, ok := r.Headers.Get("content-type")
+	if !ok {
+		return "", nil, ERROR_MISSING_CONTENT_TYPE
+	}
+	mediaType, params, err = mime.ParseMediaType(value)
+	if err != nil {
+		return "", nil, ERROR_BAD_CONTENT_TYPE
+	}
+	return mediaType, params, nil
+}
+
+// MultipartBoundary returns the boundary parameter of a multipart
+// Content-Type.
+func (r *Request) MultipartBoundary() (string, error) {
+	mediaType, params, err := r.ContentType()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return "", ERROR_NOT_MULTIPART
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" || len(boundary) > maxBoundaryLen || boundary[len(boundary)-1] == ' ' {
+		return "", ERROR_BAD_BOUNDARY
+	}
+	return boundary, nil
+}
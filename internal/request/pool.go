@@ -0,0 +1,90 @@
+package request
+
+import (
+	"io"
+	"sync"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+)
+
+// maxPooledBufSize keeps a request that grew its buffer for a large body
+// from pinning that memory in the pool.
+const maxPooledBufSize = 64 * 1024
+
+var requestPool = sync.Pool{
+	New: func() any {
+		return &Request{
+			Headers: headers.NewHeaders(),
+			parser:  &parser{buf: make([]byte, 1024)},
+		}
+	},
+}
+
+func acquireRequest(reader io.Reader) *Request {
+	r := requestPool.Get().(*Request)
+	r.state = StateInit
+	r.reader = reader
+	r.pooled = true
+	return r
+}
+
+// RequestHeadersFromReaderPooled is RequestHeadersFromReader for hot paths:
+// the Request, its read buffer and its header map come from a pool, and the
+// request target and header strings alias the read buffer instead of being
+// copied. Call Release once the request is handled; nothing taken from it,
+// including Body, may be used afterwards.
+func RequestHeadersFromReaderPooled(reader io.Reader) (*Request, error) {
+	return ReadHeadersPooled(reader, Limits{})
+}
+
+// ReadHeadersPooled is RequestHeadersFromReaderPooled within limits.
+func ReadHeadersPooled(reader io.Reader, limits Limits) (*Request, error) {
+	request := acquireRequest(reader)
+	request.headersOnly = true
+	request.limits = limits.withDefaults()
+
+	err := request.readUntil(func() bool {
+		return request.state == StateParsingBody || request.done()
+	})
+	if err != nil {
+		request.Release()
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// RequestFromReaderPooled is RequestFromReader backed by the pool; see
+// RequestHeadersFromReaderPooled.
+func RequestFromReaderPooled(reader io.Reader) (*Request, error) {
+	request, err := RequestHeadersFromReaderPooled(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := request.ReadBody(); err != nil {
+		request.Release()
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Release returns a pooled request to the pool, along with the parser and
+// header map its WithContext copies share, so it is called on one of them
+// only. It is a no-op for requests that did not come from the pool.
+func (r *Request) Release() {
+	if !r.pooled {
+		return
+	}
+
+	clear(r.Headers)
+	buf := r.buf
+	if cap(buf) > maxPooledBufSize {
+		buf = make([]byte, 1024)
+	}
+	p := r.parser
+	*p = parser{buf: buf}
+	*r = Request{Headers: r.Headers, parser: p}
+	requestPool.Put(r)
+}
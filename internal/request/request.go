@@ -2,12 +2,13 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"slices"
-	"strconv"
 	"strings"
+	"time"
+	"unsafe"
 
 	"github.com/oliverTuesta/http-tcp/internal/headers"
 )
@@ -21,59 +22,233 @@ type RequestLine struct {
 type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
-	state       parserState
-	Body        []byte
+	// Body is set by ReadBody on the copy it is called on.
+	Body []byte
+	// RemoteAddr is the client's network address, set by the server.
+	RemoteAddr string
+	// ReceivedAt is when the server started reading the request.
+	ReceivedAt time.Time
+	ctx        context.Context
+
+	*parser
+}
+
+// parser is the state of reading a request off its reader. WithContext
+// copies share it, so that the body is read once whichever copy reads it,
+// and every copy agrees on what is left buffered after it.
+type parser struct {
+	state          ParserState
+	reader         io.Reader
+	buf            []byte
+	start          int
+	bufLen         int
+	consumed       int
+	pooled         bool
+	headersOnly    bool
+	chunkSize      int
+	bodySize       int
+	streaming      bool
+	limits         Limits
+	beforeBodyRead func() error
+	afterBodyRead  func() error
+	// body is what has been read of the body. ReadBody hands it out as
+	// Body; while streaming, it holds what a bodyReader has yet to return.
+	body []byte
 }
 
 var ERROR_BAD_START_LINE = fmt.Errorf("bad request line")
 var ERROR_UNSUPPORTED_HTTP_VERSION = fmt.Errorf("unsupported http version")
 var ERROR_UNSUPPORTED_HTTP_METHOD = fmt.Errorf("unsupported http method")
+var ERROR_BAD_REQUEST_TARGET = fmt.Errorf("bad request target")
 var ERROR_UNEXPECTED_EOF = fmt.Errorf("unexpected EOF while parsing request")
-var ERROR_BODY_LARGER_THAN_CONTENT_LENGTH = fmt.Errorf("body larger than content-length")
 var SEPARATOR = []byte("\r\n")
 
-type parserState string
+type ParserState string
 
 const (
-	StateInit           parserState = "init"
-	StateDone           parserState = "done"
-	StateParsingHeaders parserState = "parsingHeaders"
-	StateParsingBody    parserState = "parsingBody"
+	StateInit           ParserState = "init"
+	StateDone           ParserState = "done"
+	StateParsingHeaders ParserState = "parsingHeaders"
+	StateParsingBody    ParserState = "parsingBody"
+	StateParsingChunks  ParserState = "parsingChunks"
+	StateParsingChunk   ParserState = "parsingChunk"
+	StateParsingTrailer ParserState = "parsingTrailer"
+	StateStreamingBody  ParserState = "streamingBody"
 )
 
-func parseRequestLine(b []byte) (*RequestLine, int, error) {
-	idx := bytes.Index(b, SEPARATOR)
+func parseRequestLine(b []byte, view bool) (RequestLine, int, error) {
+	requestLine, read, err := headers.NextLine(b)
+	if err != nil || read == 0 {
+		return RequestLine{}, 0, err
+	}
 
-	if idx == -1 {
-		return nil, 0, nil
+	method, rest, found := bytes.Cut(requestLine, []byte(" "))
+	target, version, found2 := bytes.Cut(rest, []byte(" "))
+	if !found || !found2 || bytes.IndexByte(version, ' ') != -1 {
+		return RequestLine{}, 0, ERROR_BAD_START_LINE
 	}
 
-	requestLine := b[:idx]
-	read := idx + len(SEPARATOR)
+	var rl RequestLine
 
-	parts := bytes.Split(requestLine, []byte(" "))
+	rl.Method = internMethod(method)
+	if rl.Method == "" {
+		return RequestLine{}, 0, ERROR_UNSUPPORTED_HTTP_METHOD
+	}
 
-	if len(parts) != 3 {
-		return nil, 0, ERROR_BAD_START_LINE
+	if !validRequestTarget(rl.Method, target) {
+		return RequestLine{}, 0, ERROR_BAD_REQUEST_TARGET
+	}
+	if view {
+		rl.RequestTarget = unsafe.String(&target[0], len(target))
+	} else {
+		rl.RequestTarget = string(target)
 	}
 
-	var rl RequestLine
+	if string(version) != "HTTP/1.1" {
+		return RequestLine{}, 0, ERROR_UNSUPPORTED_HTTP_VERSION
+	}
+	rl.HttpVersion = "1.1"
+
+	return rl, read, nil
 
-	rl.Method = string(parts[0])
-	validMethods := []string{"GET", "POST", "PATCH", "PUT"}
-	if !slices.Contains(validMethods, rl.Method) {
-		return nil, 0, ERROR_UNSUPPORTED_HTTP_METHOD
+}
+
+// internMethod returns the supported method spelled by b, or "".
+func internMethod(b []byte) string {
+	switch string(b) {
+	case "GET":
+		return "GET"
+	case "POST":
+		return "POST"
+	case "PATCH":
+		return "PATCH"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "CONNECT":
+		return "CONNECT"
+	case "OPTIONS":
+		return "OPTIONS"
 	}
+	return ""
+}
 
-	rl.RequestTarget = string(parts[1])
+// validRequestTarget accepts the origin-form and absolute-form targets of
+// RFC 9112 section 3.2, the authority-form only for CONNECT and the
+// asterisk-form only for OPTIONS.
+func validRequestTarget(method string, target []byte) bool {
+	if len(target) == 0 {
+		return false
+	}
+	if string(target) == "*" {
+		return method == "OPTIONS"
+	}
+	for i, c := range target {
+		if !isURIChar(c) {
+			return false
+		}
+		// pct-encoded = "%" HEXDIG HEXDIG
+		if c == '%' && (i+2 >= len(target) || !isHex(target[i+1]) || !isHex(target[i+2])) {
+			return false
+		}
+	}
 
-	if string(parts[2]) != "HTTP/1.1" {
-		return nil, 0, ERROR_UNSUPPORTED_HTTP_VERSION
+	if method == "CONNECT" {
+		idx := bytes.LastIndexByte(target, ':')
+		if idx == -1 {
+			return false
+		}
+		host, port := target[:idx], target[idx+1:]
+		return validHost(host) && len(port) > 0 && isDigits(port)
 	}
-	rl.HttpVersion = strings.Split(string(parts[2]), "/")[1]
 
-	return &rl, read, nil
+	if target[0] == '/' {
+		return true
+	}
+	scheme, rest, found := bytes.Cut(target, []byte("://"))
+	if !found || !validScheme(scheme) {
+		return false
+	}
+	authority := rest
+	if idx := bytes.IndexAny(rest, "/?"); idx != -1 {
+		authority = rest[:idx]
+	}
+	// RFC 9110 section 4.2.4: treat userinfo in an http(s) URI as an error
+	if bytes.IndexByte(authority, '@') != -1 {
+		return false
+	}
+	if idx := bytes.LastIndexByte(authority, ':'); idx != -1 && authority[len(authority)-1] != ']' {
+		if !isDigits(authority[idx+1:]) {
+			return false
+		}
+		authority = authority[:idx]
+	}
+	return validHost(authority)
+}
 
+// validScheme follows RFC 3986: ALPHA *( ALPHA / DIGIT / "+" / "-" / "." ).
+func validScheme(scheme []byte) bool {
+	if len(scheme) == 0 || !isAlpha(scheme[0]) {
+		return false
+	}
+	for _, c := range scheme[1:] {
+		if !isAlpha(c) && !isDigit(c) && c != '+' && c != '-' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// validHost accepts a reg-name or IPv4 address, or a bracketed IP-literal.
+func validHost(host []byte) bool {
+	if len(host) == 0 {
+		return false
+	}
+	if host[0] == '[' {
+		if len(host) < 3 || host[len(host)-1] != ']' {
+			return false
+		}
+		for _, c := range host[1 : len(host)-1] {
+			if !isHex(c) && c != ':' && c != '.' {
+				return false
+			}
+		}
+		return true
+	}
+	for _, c := range host {
+		if !isAlpha(c) && !isDigit(c) && strings.IndexByte("-._~!$&'()*+,;=%", c) == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isURIChar reports whether c may appear in a request-target: unreserved,
+// sub-delims, the gen-delims other than "#" and the "%" of pct-encoded.
+func isURIChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || strings.IndexByte("-._~!$&'()*+,;=:@/?[]%", c) != -1
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -82,22 +257,29 @@ func (r *Request) parse(data []byte) (int, error) {
 	for {
 		switch r.state {
 		case StateInit:
-			rl, n, err := parseRequestLine(data[read:])
+			rl, n, err := parseRequestLine(data[read:], r.pooled)
 			if err != nil {
-				return 0, err
+				return read, err
 			}
 			if n == 0 {
 				return read, nil
 			}
-			r.RequestLine = *rl
+			r.RequestLine = rl
 			read += n
 			r.state = StateParsingHeaders
 
 		case StateParsingHeaders:
-			n, done, err := r.Headers.Parse(data[read:])
+			var n int
+			var done bool
+			var err error
+			if r.pooled {
+				n, done, err = r.Headers.ParseView(data[read:])
+			} else {
+				n, done, err = r.Headers.Parse(data[read:])
+			}
 			read += n
 			if err != nil {
-				return 0, err
+				return read, err
 			}
 			if n == 0 {
 				return read, nil
@@ -108,26 +290,101 @@ func (r *Request) parse(data []byte) (int, error) {
 			}
 
 		case StateParsingBody:
-			bodyLenStr, hasContent := r.Headers.Get("content-length")
-			if !hasContent {
+			if r.headersOnly {
+				return read, nil
+			}
+			chunked, bodyLen, err := r.bodyFraming()
+			if err != nil {
+				return read, err
+			}
+			if chunked {
+				r.state = StateParsingChunks
+			} else if bodyLen < 0 {
 				r.state = StateDone
 				return read, nil
+			} else if bodyLen > r.limits.MaxBodySize {
+				return read, ERROR_BODY_TOO_LARGE
+			} else if r.streaming {
+				r.chunkSize = bodyLen
+				r.state = StateStreamingBody
 			} else {
-				bodyLen, err := strconv.Atoi(bodyLenStr)
-				if err != nil {
-					return 0, err
+				// anything past the body is the next request on the
+				// connection, and stays buffered
+				if len(data[read:]) < bodyLen {
+					return read, nil
 				}
 
-				availableBody := len(data[read:])
+				r.body = data[read : read+bodyLen]
+				r.bodySize = bodyLen
+				read += bodyLen
+				r.state = StateDone
+				return read, nil
+			}
 
-				if availableBody < bodyLen {
+		case StateParsingChunks:
+			size, n, err := parseChunkSize(data[read:])
+			if err != nil {
+				return read, err
+			}
+			if n == 0 {
+				return read, nil
+			}
+			read += n
+			if size == 0 {
+				r.state = StateParsingTrailer
+			} else {
+				r.chunkSize = size
+				r.state = StateParsingChunk
+			}
+
+		case StateStreamingBody:
+			if r.chunkSize == 0 {
+				r.state = StateDone
+				return read, nil
+			}
+			n := r.streamBody(data[read:])
+			if n == 0 {
+				return read, nil
+			}
+			read += n
+
+		case StateParsingChunk:
+			if r.bodySize+r.chunkSize > r.limits.MaxBodySize {
+				return read, ERROR_BODY_TOO_LARGE
+			}
+			if r.streaming && r.chunkSize > 0 {
+				n := r.streamBody(data[read:])
+				if n == 0 {
 					return read, nil
-				} else if availableBody > bodyLen {
-					return 0, ERROR_BODY_LARGER_THAN_CONTENT_LENGTH
 				}
-
-				r.Body = data[read : read+bodyLen]
-				read += bodyLen
+				read += n
+				continue
+			}
+			available := len(data[read:])
+			if available < r.chunkSize+len(SEPARATOR) {
+				return read, nil
+			}
+			chunk := data[read : read+r.chunkSize]
+			if !bytes.Equal(data[read+r.chunkSize:read+r.chunkSize+len(SEPARATOR)], SEPARATOR) {
+				return read, ERROR_BAD_CHUNK
+			}
+			r.body = append(r.body, chunk...)
+			r.bodySize += len(chunk)
+			read += r.chunkSize + len(SEPARATOR)
+			r.state = StateParsingChunks
+
+		case StateParsingTrailer:
+			// trailer fields are validated like headers but not kept
+			n, done, err := headers.NewHeaders().Parse(data[read:])
+			if err != nil {
+				return read, err
+			}
+			if n == 0 {
+				return read, nil
+			}
+			read += n
+			if done {
+				r.finishChunkedBody()
 				r.state = StateDone
 				return read, nil
 			}
@@ -142,40 +399,163 @@ func (r *Request) done() bool {
 	return r.state == StateDone
 }
 
+// Buffered returns the bytes read from the connection but not yet parsed:
+// the unread body of a request whose body is still pending, or what follows
+// the request, e.g. the first bytes of a protocol the connection is
+// upgraded to.
+func (r *Request) Buffered() []byte {
+	if r.bufLen == r.start {
+		return nil
+	}
+	return bytes.Clone(r.buf[r.start:r.bufLen])
+}
+
+// Context is cancelled when the client goes away while the request is
+// being handled.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+// The copy shares r's parser: reading the body through either reads it for
+// both, and Release on either releases both.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
 func NewRequest() *Request {
 	return &Request{
-		state:   StateInit,
 		Headers: headers.NewHeaders(),
+		parser: &parser{
+			state:  StateInit,
+			limits: Limits{}.withDefaults(),
+		},
 	}
 }
 
+// RequestHeadersFromReader parses the request line and headers only, within
+// the default Limits. The body stays on the reader until ReadBody is
+// called.
+func RequestHeadersFromReader(reader io.Reader) (*Request, error) {
+	return ReadHeaders(reader, Limits{})
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	request, err := RequestHeadersFromReader(reader)
+	if err != nil {
+		return nil, err
+	}
 
-	request := NewRequest()
+	if err := request.ReadBody(); err != nil {
+		return nil, err
+	}
 
-	buf := make([]byte, 1024)
-	bufLen := 0
+	return request, nil
+}
 
-	for !request.done() {
-		n, err := reader.Read(buf[bufLen:])
-		if err != nil && err != io.EOF {
-			return nil, errors.Join(fmt.Errorf("unable to read"), err)
+// ReadBody reads the rest of a request obtained from
+// RequestHeadersFromReader into Body. Once the body is read, by this
+// request or a copy of it, it only sets Body.
+func (r *Request) ReadBody() error {
+	if r.reader == nil {
+		return nil
+	}
+
+	if !r.done() {
+		if err := r.runBeforeBodyRead(); err != nil {
+			return err
 		}
 
-		bufLen += n
-		readN, err := request.parse(buf[:bufLen])
+		r.headersOnly = false
+		if err := r.readUntil(r.done); err != nil {
+			return err
+		}
+	}
+
+	err := r.runAfterBodyRead()
+	r.Body = r.body
+	return err
+}
+
+// BodyRead reports whether the body has been read off the reader in full,
+// through this request or any copy of it.
+func (r *Request) BodyRead() bool {
+	return r.done()
+}
+
+// BeforeBodyRead registers fn to run the first time ReadBody or BodyReader
+// needs to read from the connection, e.g. to send "100 Continue".
+func (r *Request) BeforeBodyRead(fn func() error) {
+	r.beforeBodyRead = fn
+}
 
+// AfterBodyRead registers fn to run once the full body has been read, by
+// ReadBody or to the end of a BodyReader.
+func (r *Request) AfterBodyRead(fn func() error) {
+	r.afterBodyRead = fn
+}
+
+func (r *Request) readUntil(stop func() bool) error {
+	lastRead := -1
+
+	for {
+		readN, err := r.parse(r.buf[r.start:r.bufLen])
 		if err != nil {
-			return nil, err
+			return r.parseError(err, readN)
+		}
+		r.start += readN
+		r.consumed += readN
+
+		if stop() {
+			return nil
+		}
+
+		if readN == 0 && lastRead == 0 {
+			return r.parseError(ERROR_UNEXPECTED_EOF, r.bufLen-r.start)
+		}
+		if r.inHead() && r.consumed+r.bufLen-r.start >= r.limits.MaxHeaderBytes {
+			return r.parseError(ERROR_HEADERS_TOO_LARGE, 0)
 		}
 
-		if readN == 0 && n == 0 && !request.done() {
-			return nil, ERROR_UNEXPECTED_EOF
+		if r.bufLen == len(r.buf) {
+			if !r.makeRoom() {
+				return r.parseError(r.tooLarge(), 0)
+			}
+		}
+
+		n, err := r.reader.Read(r.buf[r.bufLen:])
+		if err != nil && err != io.EOF {
+			return errors.Join(fmt.Errorf("unable to read"), err)
 		}
 
-		copy(buf, buf[readN:bufLen])
-		bufLen -= readN
+		r.bufLen += n
+		lastRead = n
 	}
+}
 
-	return request, nil
+// makeRoom frees space at the end of a full buffer. Parsed bytes are only
+// dropped by moving the rest down when nothing aliases them; a pooled
+// request's strings do, so it moves to a bigger buffer instead, up to the
+// limits. It reports false if there is no room to be had.
+func (r *Request) makeRoom() bool {
+	unparsed := r.buf[r.start:r.bufLen]
+	if !r.pooled && r.start > 0 {
+		copy(r.buf, unparsed)
+	} else {
+		size := min(2*len(r.buf), r.limits.maxBuffer())
+		if size <= len(r.buf) {
+			return false
+		}
+		grown := make([]byte, size)
+		copy(grown, unparsed)
+		r.buf = grown
+	}
+	r.bufLen = len(unparsed)
+	r.start = 0
+	return true
 }
@@ -1,9 +1,17 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"io"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/oliverTuesta/http-tcp/internal/headers"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -70,6 +78,59 @@ func TestRequestLineParse(t *testing.T) {
 				require.Error(t, err)
 			},
 		},
+		{
+			name:  "Good CONNECT Request line",
+			data:  "CONNECT [::1]:443 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			chunk: 4,
+			assert: func(t *testing.T, r *Request, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, "[::1]:443", r.RequestLine.RequestTarget)
+			},
+		},
+		{
+			name:  "Good DELETE Request line",
+			data:  "DELETE /coffee/1 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			chunk: 4,
+			assert: func(t *testing.T, r *Request, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, "DELETE", r.RequestLine.Method)
+				assert.Equal(t, "/coffee/1", r.RequestLine.RequestTarget)
+			},
+		},
+		{
+			name:  "Method case",
+			data:  "delete /coffee/1 HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			chunk: 4,
+			assert: func(t *testing.T, r *Request, err error) {
+				require.ErrorIs(t, err, ERROR_UNSUPPORTED_HTTP_METHOD)
+			},
+		},
+		{
+			name:  "Good OPTIONS Request line",
+			data:  "OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			chunk: 4,
+			assert: func(t *testing.T, r *Request, err error) {
+				require.NoError(t, err)
+				assert.Equal(t, "OPTIONS", r.RequestLine.Method)
+				assert.Equal(t, "*", r.RequestLine.RequestTarget)
+			},
+		},
+		{
+			name:  "Bad request targets",
+			data:  "GET  HTTP/1.1\r\n",
+			chunk: 4,
+			assert: func(t *testing.T, r *Request, err error) {
+				require.ErrorIs(t, err, ERROR_BAD_REQUEST_TARGET)
+				for _, target := range []string{"coffee", "/%zz", "/a#frag", "/\"x\"", "0://host", "http://#", "http://h:port/"} {
+					_, err := RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\n\r\n"))
+					assert.ErrorIs(t, err, ERROR_BAD_REQUEST_TARGET, target)
+				}
+				_, err = RequestFromReader(strings.NewReader("CONNECT example.com:https HTTP/1.1\r\n\r\n"))
+				assert.ErrorIs(t, err, ERROR_BAD_REQUEST_TARGET)
+				_, err = RequestFromReader(strings.NewReader("GET * HTTP/1.1\r\n\r\n"))
+				assert.ErrorIs(t, err, ERROR_BAD_REQUEST_TARGET)
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -224,3 +285,512 @@ func TestRequestBodyParsing(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 }
+
+func gzipBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zlibBytes(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func encodedRequest(encoding string, body []byte) string {
+	return "POST /upload HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Encoding: " + encoding + "\r\n" +
+		"Content-Length: " + strconv.Itoa(len(body)) + "\r\n" +
+		"\r\n" +
+		string(body)
+}
+
+func TestRequestBodyDecoding(t *testing.T) {
+	payload := `{"hello":"world"}`
+
+	t.Run("gzip", func(t *testing.T) {
+		reader := &chunkReader{data: encodedRequest("gzip", gzipBytes(t, payload)), numBytesPerRead: 7}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		require.NoError(t, r.DecodeBody(1024))
+		assert.Equal(t, payload, string(r.Body))
+		_, hasEncoding := r.Headers.Get("content-encoding")
+		assert.False(t, hasEncoding)
+		assert.Equal(t, strconv.Itoa(len(payload)), r.Headers["content-length"])
+	})
+
+	t.Run("deflate", func(t *testing.T) {
+		reader := &chunkReader{data: encodedRequest("deflate", zlibBytes(t, payload)), numBytesPerRead: 7}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		require.NoError(t, r.DecodeBody(1024))
+		assert.Equal(t, payload, string(r.Body))
+	})
+
+	t.Run("stacked codings", func(t *testing.T) {
+		body := gzipBytes(t, string(zlibBytes(t, payload)))
+		reader := &chunkReader{data: encodedRequest("deflate, gzip", body), numBytesPerRead: 7}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		require.NoError(t, r.DecodeBody(1024))
+		assert.Equal(t, payload, string(r.Body))
+	})
+
+	t.Run("decoded size over limit", func(t *testing.T) {
+		bomb := gzipBytes(t, strings.Repeat("a", 4096))
+		reader := &chunkReader{data: encodedRequest("gzip", bomb), numBytesPerRead: 7}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.ErrorIs(t, r.DecodeBody(1024), ERROR_DECODED_BODY_TOO_LARGE)
+	})
+
+	t.Run("unsupported coding", func(t *testing.T) {
+		reader := &chunkReader{data: encodedRequest("br", []byte("abc")), numBytesPerRead: 7}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.ErrorIs(t, r.DecodeBody(1024), ERROR_UNSUPPORTED_CONTENT_ENCODING)
+	})
+
+	t.Run("corrupt gzip", func(t *testing.T) {
+		reader := &chunkReader{data: encodedRequest("gzip", []byte("not gzip")), numBytesPerRead: 7}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.ErrorIs(t, r.DecodeBody(1024), ERROR_MALFORMED_ENCODED_BODY)
+	})
+}
+
+func TestRequestBuffered(t *testing.T) {
+	reader := &chunkReader{
+		data: "GET /chat HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"\x81\x85",
+		numBytesPerRead: 64,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("\x81\x85"), r.Buffered())
+
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Empty(t, r.Buffered())
+}
+
+func TestRequestDeferredBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Expect: 100-continue\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestHeadersFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "100-continue", r.Headers["expect"])
+	assert.Empty(t, r.Body)
+
+	calls := []string{}
+	r.BeforeBodyRead(func() error {
+		calls = append(calls, "before")
+		return nil
+	})
+	r.AfterBodyRead(func() error {
+		calls = append(calls, "after")
+		return nil
+	})
+
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, []string{"before", "after"}, calls)
+}
+
+func TestRequestWithContextSharesBody(t *testing.T) {
+	r, err := RequestHeadersFromReader(strings.NewReader("POST /a HTTP/1.1\r\n" +
+		"Content-Length: 26\r\n" +
+		"\r\n" +
+		"GET /smuggled HTTP/1.1\r\n\r\n" +
+		"GET /next HTTP/1.1\r\n\r\n"))
+	require.NoError(t, err)
+
+	after := 0
+	r.AfterBodyRead(func() error {
+		after++
+		return nil
+	})
+
+	// middleware reads the body through its own copy
+	r2 := r.WithContext(context.Background())
+	require.NoError(t, r2.ReadBody())
+	assert.Equal(t, "GET /smuggled HTTP/1.1\r\n\r\n", string(r2.Body))
+
+	// and the original sees it read, with only the next request left over
+	assert.True(t, r.BodyRead())
+	assert.Equal(t, "GET /next HTTP/1.1\r\n\r\n", string(r.Buffered()))
+	require.NoError(t, r.ReadBody())
+	assert.Equal(t, "GET /smuggled HTTP/1.1\r\n\r\n", string(r.Body))
+	assert.Equal(t, 1, after)
+}
+
+func TestRequestLargeBody(t *testing.T) {
+	body := strings.Repeat("x", 5000)
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5000\r\n" +
+			"\r\n" +
+			body,
+		numBytesPerRead: 700,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(r.Body))
+}
+
+func TestRequestLimits(t *testing.T) {
+	limits := Limits{MaxHeaderBytes: 256, MaxBodySize: 1024}
+
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", 300) + "\r\n\r\n",
+		numBytesPerRead: 50,
+	}
+	_, err := ReadHeaders(reader, limits)
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 431, parseErr.Status)
+
+	// a header that never ends is cut off at the limit, not buffered
+	_, err = ReadHeaders(strings.NewReader("GET / HTTP/1.1\r\nX-Big: "+strings.Repeat("a", 1<<20)), limits)
+	assert.ErrorIs(t, err, ERROR_HEADERS_TOO_LARGE)
+
+	// and so is a request line, which is the URI's fault
+	_, err = ReadHeaders(strings.NewReader("GET /"+strings.Repeat("a", 1<<20)), limits)
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 414, parseErr.Status)
+
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nContent-Length: 2048\r\n\r\n" + strings.Repeat("x", 2048),
+		numBytesPerRead: 700,
+	}
+	r, err := ReadHeaders(reader, limits)
+	require.NoError(t, err)
+	assert.ErrorIs(t, r.ReadBody(), ERROR_BODY_TOO_LARGE)
+
+	// chunked bodies are held to the running total, before the chunk that
+	// would cross it is buffered
+	chunk := "200\r\n" + strings.Repeat("x", 512) + "\r\n"
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n" + chunk + chunk + chunk + "0\r\n\r\n",
+		numBytesPerRead: 100,
+	}
+	r, err = ReadHeaders(reader, limits)
+	require.NoError(t, err)
+	err = r.ReadBody()
+	assert.ErrorIs(t, err, ERROR_BODY_TOO_LARGE)
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, 413, parseErr.Status)
+}
+
+func TestRequestBodyReader(t *testing.T) {
+	for name, head := range map[string]string{
+		"length":  "POST / HTTP/1.1\r\nContent-Length: 10\r\n\r\n",
+		"chunked": "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\na\r\n",
+	} {
+		pr, pw := io.Pipe()
+		go pw.Write([]byte(head + "hello"))
+		r, err := RequestHeadersFromReader(pr)
+		require.NoError(t, err, name)
+		body, err := r.BodyReader()
+		require.NoError(t, err, name)
+
+		// the first half is handed out before the second is sent
+		p := make([]byte, 64)
+		n, err := io.ReadAtLeast(body, p, 5)
+		require.NoError(t, err, name)
+		assert.Equal(t, "hello", string(p[:n]), name)
+
+		rest := "world"
+		if name == "chunked" {
+			rest += "\r\n0\r\n\r\n"
+		}
+		go func() {
+			pw.Write([]byte(rest + "GET /next HTTP/1.1\r\n"))
+			pw.Close()
+		}()
+		remaining, err := io.ReadAll(body)
+		require.NoError(t, err, name)
+		assert.Equal(t, "world", string(remaining), name)
+		assert.Equal(t, "10", r.Headers["content-length"], name)
+		assert.True(t, r.done(), name)
+	}
+}
+
+func TestRequestChunkedBody(t *testing.T) {
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"7;ext=1\r\nworld!\n\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "13", r.Headers["content-length"])
+	_, hasTE := r.Headers.Get("transfer-encoding")
+	assert.False(t, hasTE)
+}
+
+// Each payload is a known request smuggling or desync vector; the parser
+// must refuse it with the matching error instead of guessing a framing.
+func TestRequestSmugglingCorpus(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{
+			name: "CL.TE",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 6\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nG",
+			err:  ERROR_CONTENT_LENGTH_WITH_TRANSFER_ENCODING,
+		},
+		{
+			name: "TE.CL",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\nContent-Length: 4\r\n\r\n5c\r\nGPOST / HTTP/1.1\r\n\r\n0\r\n\r\n",
+			err:  ERROR_CONTENT_LENGTH_WITH_TRANSFER_ENCODING,
+		},
+		{
+			name: "Conflicting duplicate Content-Length",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello",
+			err:  ERROR_CONFLICTING_CONTENT_LENGTH,
+		},
+		{
+			name: "Comma separated conflicting Content-Length",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5, 6\r\n\r\nhello",
+			err:  ERROR_CONFLICTING_CONTENT_LENGTH,
+		},
+		{
+			name: "Negative Content-Length",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: -1\r\n\r\n",
+			err:  ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name: "Signed Content-Length",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: +5\r\n\r\nhello",
+			err:  ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name: "Hex Content-Length",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 0x5\r\n\r\nhello",
+			err:  ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name: "Overflowing Content-Length",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 99999999999999999999\r\n\r\n",
+			err:  ERROR_INVALID_CONTENT_LENGTH,
+		},
+		{
+			name: "Chunked not final",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n",
+			err:  ERROR_CHUNKED_NOT_FINAL,
+		},
+		{
+			name: "Obfuscated Transfer-Encoding",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n",
+			err:  ERROR_CHUNKED_NOT_FINAL,
+		},
+		{
+			name: "Unsupported transfer coding",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: gzip, chunked\r\n\r\n0\r\n\r\n",
+			err:  ERROR_UNSUPPORTED_TRANSFER_ENCODING,
+		},
+		{
+			name: "Bad chunk size",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n-1\r\n\r\n",
+			err:  ERROR_BAD_CHUNK_SIZE,
+		},
+		{
+			name: "Chunk longer than declared",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nhello\r\n0\r\n\r\n",
+			err:  ERROR_BAD_CHUNK,
+		},
+		{
+			name: "Whitespace before colon",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n",
+			err:  headers.ERROR_WHITESPACE_BEFORE_COLON,
+		},
+		{
+			name: "Obs-fold",
+			data: "POST / HTTP/1.1\r\nHost: a\r\nX-Folded: one\r\n two\r\n\r\n",
+			err:  headers.ERROR_OBS_FOLD,
+		},
+		{
+			name: "Whitespace before first header",
+			data: "POST / HTTP/1.1\r\n Transfer-Encoding: chunked\r\nHost: a\r\n\r\n",
+			err:  headers.ERROR_OBS_FOLD,
+		},
+		{
+			name: "Bare LF in headers",
+			data: "POST / HTTP/1.1\r\nHost: a\nContent-Length: 5\r\n\r\nhello",
+			err:  headers.ERROR_BARE_LF,
+		},
+		{
+			name: "Bare LF request line",
+			data: "GET / HTTP/1.1\nHost: a\n\n",
+			err:  headers.ERROR_BARE_LF,
+		},
+		{
+			name: "Bare CR in header value",
+			data: "GET / HTTP/1.1\r\nHost: a\rX: b\r\n\r\n",
+			err:  headers.ERROR_BARE_CR,
+		},
+		{
+			name: "NUL in header value",
+			data: "GET / HTTP/1.1\r\nHost: a\x00b\r\n\r\n",
+			err:  headers.ERROR_NUL_BYTE,
+		},
+		{
+			name: "NUL in request line",
+			data: "GET /\x00 HTTP/1.1\r\nHost: a\r\n\r\n",
+			err:  headers.ERROR_NUL_BYTE,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, chunk := range []int{1, 3, len(tt.data)} {
+				reader := &chunkReader{
+					data:            tt.data,
+					numBytesPerRead: chunk,
+				}
+				_, err := RequestFromReader(reader)
+				require.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+
+	t.Run("Identical duplicate Content-Length", func(t *testing.T) {
+		reader := &chunkReader{
+			data:            "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\nContent-Length: 5\r\n\r\nhello",
+			numBytesPerRead: 3,
+		}
+		r, err := RequestFromReader(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(r.Body))
+	})
+}
+
+func TestRequestParseError(t *testing.T) {
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nUser-Agent : curl\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err := RequestFromReader(reader)
+
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.ErrorIs(t, err, headers.ERROR_WHITESPACE_BEFORE_COLON)
+	assert.Equal(t, StateParsingHeaders, parseErr.State)
+	assert.Equal(t, 33, parseErr.Offset)
+	assert.Equal(t, "User-Agent : curl\r\n", parseErr.Snippet)
+	assert.Equal(t, 400, parseErr.Status)
+
+	reader = &chunkReader{
+		data:            "POST /coffee HTTP/1.2\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorAs(t, err, &parseErr)
+	assert.ErrorIs(t, err, ERROR_UNSUPPORTED_HTTP_VERSION)
+	assert.Equal(t, StateInit, parseErr.State)
+	assert.Equal(t, 0, parseErr.Offset)
+	assert.Equal(t, 505, parseErr.Status)
+
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost",
+		numBytesPerRead: 5,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorAs(t, err, &parseErr)
+	assert.ErrorIs(t, err, ERROR_UNEXPECTED_EOF)
+	assert.Equal(t, StateParsingHeaders, parseErr.State)
+	assert.Equal(t, 31, parseErr.Offset)
+}
+
+func TestRequestPooled(t *testing.T) {
+	// a header long enough that the buffer has to grow mid-request
+	long := strings.Repeat("x", 3000)
+	inputs := append(slices.Clone(seedRequests), "GET / HTTP/1.1\r\nHost: a\r\nX-Long: "+long+"\r\nX-After: yes\r\n\r\n")
+
+	for _, input := range inputs {
+		for _, chunk := range []int{1, 3, len(input)} {
+			want, wantErr := RequestFromReader(&chunkReader{data: input, numBytesPerRead: chunk})
+			got, err := RequestFromReaderPooled(&chunkReader{data: input, numBytesPerRead: chunk})
+			if wantErr != nil {
+				assert.Error(t, err, input)
+				continue
+			}
+			require.NoError(t, err, input)
+			assert.Equal(t, want.RequestLine, got.RequestLine)
+			assert.Equal(t, want.Headers, got.Headers)
+			assert.Equal(t, want.Body, got.Body)
+			got.Release()
+		}
+	}
+
+	// a released request comes back clean
+	r, err := RequestFromReaderPooled(strings.NewReader("GET / HTTP/1.1\r\nHost: a\r\nX-Old: 1\r\n\r\n"))
+	require.NoError(t, err)
+	r.Release()
+	r, err = RequestFromReaderPooled(strings.NewReader("PUT /b HTTP/1.1\r\nHost: b\r\nContent-Length: 2\r\n\r\nhi"))
+	require.NoError(t, err)
+	assert.Equal(t, headers.Headers{"host": "b", "content-length": "2"}, r.Headers)
+	assert.Equal(t, "hi", string(r.Body))
+	r.Release()
+}
+
+func TestMultipartBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		contentType string
+		boundary    string
+		err         error
+	}{
+		{`multipart/form-data; boundary=abc123`, "abc123", nil},
+		{`Multipart/Form-Data; boundary="a b:c"`, "a b:c", nil},
+		{`multipart/form-data`, "", ERROR_BAD_BOUNDARY},
+		{`multipart/form-data; boundary=` + strings.Repeat("a", 71), "", ERROR_BAD_BOUNDARY},
+		{`application/json`, "", ERROR_NOT_MULTIPART},
+		{`multipart/form-data; boundary`, "", ERROR_BAD_CONTENT_TYPE},
+		{"", "", ERROR_MISSING_CONTENT_TYPE},
+	} {
+		raw := "POST / HTTP/1.1\r\nHost: x\r\n"
+		if tc.contentType != "" {
+			raw += "Content-Type: " + tc.contentType + "\r\n"
+		}
+		req, err := RequestFromReader(strings.NewReader(raw + "\r\n"))
+		require.NoError(t, err)
+
+		boundary, err := req.MultipartBoundary()
+		assert.ErrorIs(t, err, tc.err, tc.contentType)
+		assert.Equal(t, tc.boundary, boundary, tc.contentType)
+	}
+}
@@ -0,0 +1,82 @@
+package request
+
+import (
+	"bytes"
+	"io"
+)
+
+// BodyReader returns the body of a request obtained from
+// RequestHeadersFromReader as it arrives, instead of reading all of it
+// into Body first. A body with a content coding is read whole with ReadBody, so that
+// it can be decoded, and so is one that was already read.
+func (r *Request) BodyReader() (io.Reader, error) {
+	if _, encoded := r.Headers.Get("content-encoding"); encoded || r.done() || r.reader == nil {
+		if err := r.ReadBody(); err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(r.Body), nil
+	}
+
+	if err := r.runBeforeBodyRead(); err != nil {
+		return nil, err
+	}
+	r.headersOnly = false
+	r.streaming = true
+	return &bodyReader{r: r}, nil
+}
+
+type bodyReader struct {
+	r *Request
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	if len(r.body) == 0 && !r.done() {
+		err := r.readUntil(func() bool {
+			return len(r.body) > 0 || r.done()
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(r.body) == 0 {
+		if err := r.runAfterBodyRead(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+
+	n := copy(p, r.body)
+	r.body = r.body[n:]
+	return n, nil
+}
+
+// streamBody moves up to chunkSize bytes of data into body for a
+// bodyReader to hand out. body never aliases the read buffer here, since
+// the buffer is reused as the rest of the body arrives.
+func (r *Request) streamBody(data []byte) int {
+	n := min(len(data), r.chunkSize)
+	r.body = append(r.body, data[:n]...)
+	r.chunkSize -= n
+	r.bodySize += n
+	return n
+}
+
+func (r *Request) runBeforeBodyRead() error {
+	if r.beforeBodyRead == nil {
+		return nil
+	}
+	fn := r.beforeBodyRead
+	r.beforeBodyRead = nil
+	return fn()
+}
+
+func (r *Request) runAfterBodyRead() error {
+	if r.afterBodyRead == nil {
+		return nil
+	}
+	fn := r.afterBodyRead
+	r.afterBodyRead = nil
+	return fn()
+}
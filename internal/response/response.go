@@ -11,20 +11,62 @@ import (
 type StatusCode string
 
 const (
-	StatusOk                  StatusCode = "200 OK"
-	StatusBadRequest          StatusCode = "400 Bad Request"
-	StatusNotFound            StatusCode = "404 Not Found"
-	StatusInternalServerError StatusCode = "500 Internal Server Error"
+	StatusContinue                    StatusCode = "100 Continue"
+	StatusSwitchingProtocols          StatusCode = "101 Switching Protocols"
+	StatusProcessing                  StatusCode = "102 Processing"
+	StatusEarlyHints                  StatusCode = "103 Early Hints"
+	StatusOk                          StatusCode = "200 OK"
+	StatusNoContent                   StatusCode = "204 No Content"
+	StatusNotModified                 StatusCode = "304 Not Modified"
+	StatusBadRequest                  StatusCode = "400 Bad Request"
+	StatusForbidden                   StatusCode = "403 Forbidden"
+	StatusNotFound                    StatusCode = "404 Not Found"
+	StatusNotAcceptable               StatusCode = "406 Not Acceptable"
+	StatusProxyAuthRequired           StatusCode = "407 Proxy Authentication Required"
+	StatusRequestTimeout              StatusCode = "408 Request Timeout"
+	StatusPreconditionFailed          StatusCode = "412 Precondition Failed"
+	StatusContentTooLarge             StatusCode = "413 Content Too Large"
+	StatusURITooLong                  StatusCode = "414 URI Too Long"
+	StatusUnsupportedMedia            StatusCode = "415 Unsupported Media Type"
+	StatusExpectationFailed           StatusCode = "417 Expectation Failed"
+	StatusTooManyRequests             StatusCode = "429 Too Many Requests"
+	StatusRequestHeaderFieldsTooLarge StatusCode = "431 Request Header Fields Too Large"
+	StatusInternalServerError         StatusCode = "500 Internal Server Error"
+	StatusNotImplemented              StatusCode = "501 Not Implemented"
+	StatusBadGateway                  StatusCode = "502 Bad Gateway"
+	StatusServiceUnavailable          StatusCode = "503 Service Unavailable"
+	StatusHTTPVersionNotSupported     StatusCode = "505 HTTP Version Not Supported"
 )
 
 var ERROR_INVALID_STATUS_CODE = fmt.Errorf("invalid status code")
 
 func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
 	switch statusCode {
-		case StatusOk,
+	case StatusContinue,
+		StatusSwitchingProtocols,
+		StatusProcessing,
+		StatusEarlyHints,
+		StatusOk,
+		StatusNoContent,
+		StatusNotModified,
 		StatusBadRequest,
+		StatusForbidden,
 		StatusNotFound,
-		StatusInternalServerError:
+		StatusNotAcceptable,
+		StatusProxyAuthRequired,
+		StatusRequestTimeout,
+		StatusPreconditionFailed,
+		StatusContentTooLarge,
+		StatusURITooLong,
+		StatusUnsupportedMedia,
+		StatusExpectationFailed,
+		StatusTooManyRequests,
+		StatusRequestHeaderFieldsTooLarge,
+		StatusInternalServerError,
+		StatusNotImplemented,
+		StatusBadGateway,
+		StatusServiceUnavailable,
+		StatusHTTPVersionNotSupported:
 	default:
 		return ERROR_INVALID_STATUS_CODE
 	}
@@ -36,19 +78,17 @@ func WriteStatusLine(w io.Writer, statusCode StatusCode) error {
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	var headers = headers.NewHeaders()
 	headers.Add("content-length", strconv.Itoa(contentLen))
-	headers.Add("connection", "close")
 	headers.Add("content-type", "text/plain")
 	return headers
 }
 
 func WriteHeaders(w io.Writer, headers headers.Headers) error {
 	for key, value := range headers {
-		_, err := w.Write([]byte(key + ": " + value + "\r\n"))	
+		_, err := w.Write([]byte(key + ": " + value + "\r\n"))
 		if err != nil {
 			return err
 		}
 	}
-	_, err := w.Write([]byte("\r\n"))	
+	_, err := w.Write([]byte("\r\n"))
 	return err
 }
-
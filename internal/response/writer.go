@@ -0,0 +1,381 @@
+package response
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+)
+
+type writerState int
+
+const (
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+)
+
+var ERROR_WRITER_STATE = fmt.Errorf("response parts written out of order")
+var ERROR_HIJACKED = fmt.Errorf("connection has been hijacked")
+var ERROR_NOT_INFORMATIONAL = fmt.Errorf("not an informational status code")
+var ERROR_INVALID_FIELD = fmt.Errorf("field name or value contains a line break")
+var ERROR_NO_CONNECTION = fmt.Errorf("writer has no connection")
+
+type Writer struct {
+	conn     net.Conn
+	out      io.Writer
+	bw       *bufio.Writer
+	buffered []byte
+	state    writerState
+	status   StatusCode
+	hijacked bool
+	onHijack func() []byte
+	onStatus []func(StatusCode)
+	onHeader []func()
+	record   *Recording
+	// field lines queued by AddHeader, which may repeat a name
+	extra [][2]string
+
+	// how the final response is framed, for KeepAlive
+	bodyless      bool
+	contentLength int
+	chunked       bool
+	chunkedDone   bool
+	bodyWritten   int
+	closeAfter    bool
+}
+
+// NewWriter returns a Writer for conn. buffered holds bytes already read
+// from conn that the request parser did not consume; they are handed back
+// by Hijack.
+func NewWriter(conn net.Conn, buffered []byte) *Writer {
+	return &Writer{
+		conn:     conn,
+		out:      conn,
+		buffered: buffered,
+		state:    writerStateStatusLine,
+	}
+}
+
+// NewDetachedWriter returns a Writer that writes to out instead of a
+// client connection, to run a handler for its response alone. It cannot
+// be hijacked.
+func NewDetachedWriter(out io.Writer) *Writer {
+	return &Writer{
+		out:   out,
+		state: writerStateStatusLine,
+	}
+}
+
+// NewBufferedWriter returns a Writer that writes to conn through bw. The
+// response reaches the client on Flush, or when bw fills up.
+func NewBufferedWriter(conn net.Conn, bw *bufio.Writer) *Writer {
+	return &Writer{
+		conn:  conn,
+		out:   bw,
+		bw:    bw,
+		state: writerStateStatusLine,
+	}
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if w.hijacked {
+		return ERROR_HIJACKED
+	}
+	if w.state != writerStateStatusLine {
+		return ERROR_WRITER_STATE
+	}
+	if isInformational(statusCode) {
+		return ERROR_WRITER_STATE
+	}
+
+	if err := WriteStatusLine(w.out, statusCode); err != nil {
+		return err
+	}
+	w.state = writerStateHeaders
+	w.status = statusCode
+	w.bodyless = bodyless(statusCode)
+	if w.record != nil {
+		w.record.Status = statusCode
+	}
+	for _, fn := range w.onStatus {
+		fn(statusCode)
+	}
+	return nil
+}
+
+// WriteInformational sends an interim 1xx response, such as 103 Early
+// Hints, with its own header block. Any number may precede the final
+// status line.
+func (w *Writer) WriteInformational(statusCode StatusCode, h headers.Headers) error {
+	if w.hijacked {
+		return ERROR_HIJACKED
+	}
+	if w.state != writerStateStatusLine {
+		return ERROR_WRITER_STATE
+	}
+	if !isInformational(statusCode) {
+		return ERROR_NOT_INFORMATIONAL
+	}
+
+	if err := WriteStatusLine(w.out, statusCode); err != nil {
+		return err
+	}
+	if err := WriteHeaders(w.out, h); err != nil {
+		return err
+	}
+	// an interim response is only useful if it arrives before the final one
+	return w.Flush()
+}
+
+// 101 Switching Protocols is a 1xx code but it ends the HTTP exchange, so
+// it is written as the final status line.
+func isInformational(statusCode StatusCode) bool {
+	return strings.HasPrefix(string(statusCode), "1") && statusCode != StatusSwitchingProtocols
+}
+
+// responses to these never carry a body, whatever their headers say
+func bodyless(statusCode StatusCode) bool {
+	return strings.HasPrefix(string(statusCode), "1") ||
+		strings.HasPrefix(string(statusCode), "204 ") ||
+		strings.HasPrefix(string(statusCode), "304 ")
+}
+
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if w.hijacked {
+		return ERROR_HIJACKED
+	}
+	if w.state != writerStateHeaders {
+		return ERROR_WRITER_STATE
+	}
+
+	for _, field := range w.extra {
+		if _, err := w.out.Write([]byte(field[0] + ": " + field[1] + "\r\n")); err != nil {
+			return err
+		}
+	}
+	if w.record != nil {
+		w.record.Fields = append(w.record.Fields, w.extra[w.record.queued:]...)
+		for key, value := range h {
+			w.record.Fields = append(w.record.Fields, [2]string{key, value})
+		}
+	}
+	w.extra = nil
+	if err := WriteHeaders(w.out, h); err != nil {
+		return err
+	}
+	w.state = writerStateBody
+	w.recordFraming(h)
+	for _, fn := range w.onHeader {
+		fn()
+	}
+	return nil
+}
+
+// AddHeader queues a field line to go out with the next WriteHeaders, on
+// top of the headers passed to it. Unlike a Headers map it may hold the
+// same name several times, which Set-Cookie needs since its values cannot
+// be joined into one line.
+func (w *Writer) AddHeader(name, value string) error {
+	if w.hijacked {
+		return ERROR_HIJACKED
+	}
+	if w.state == writerStateBody {
+		return ERROR_WRITER_STATE
+	}
+	if strings.ContainsAny(name, "\r\n:") || strings.ContainsAny(value, "\r\n") {
+		return ERROR_INVALID_FIELD
+	}
+	w.extra = append(w.extra, [2]string{strings.ToLower(name), value})
+	return nil
+}
+
+func (w *Writer) recordFraming(h headers.Headers) {
+	w.contentLength = -1
+	if value, ok := h.Get("content-length"); ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			w.contentLength = n
+		}
+	}
+	if value, ok := h.Get("transfer-encoding"); ok {
+		w.chunked = strings.Contains(strings.ToLower(value), "chunked")
+	}
+	if value, ok := h.Get("connection"); ok {
+		w.closeAfter = strings.Contains(strings.ToLower(value), "close")
+	}
+}
+
+// KeepAlive reports whether the connection can carry another request once
+// this response is complete: the client must be able to tell where the
+// body ends, and it must have been written in full.
+func (w *Writer) KeepAlive() bool {
+	switch {
+	case w.hijacked || w.state != writerStateBody || w.closeAfter:
+		return false
+	case w.bodyless:
+		return w.bodyWritten == 0
+	case w.chunked:
+		return w.chunkedDone
+	default:
+		return w.contentLength >= 0 && w.bodyWritten == w.contentLength
+	}
+}
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ERROR_HIJACKED
+	}
+	if w.state != writerStateBody {
+		return 0, ERROR_WRITER_STATE
+	}
+
+	n, err := w.out.Write(p)
+	w.bodyWritten += n
+	if w.record != nil {
+		w.record.add(p[:n])
+	}
+	return n, err
+}
+
+// Write makes the Writer usable as an io.Writer once the headers are out.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// WriteChunkedBody writes p as one chunk of a "transfer-encoding: chunked"
+// body. Empty writes are skipped since a zero-length chunk ends the body.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := make([]byte, 0, len(p)+12)
+	chunk = strconv.AppendInt(chunk, int64(len(p)), 16)
+	chunk = append(chunk, "\r\n"...)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+
+	if _, err := w.WriteBody(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *Writer) WriteChunkedBodyDone() error {
+	if _, err := w.WriteBody([]byte("0\r\n\r\n")); err != nil {
+		return err
+	}
+	w.chunkedDone = true
+	return nil
+}
+
+// Flush pushes buffered response bytes to the client. It is a no-op for a
+// Writer from NewWriter, which writes straight to the connection.
+func (w *Writer) Flush() error {
+	if w.hijacked {
+		return ERROR_HIJACKED
+	}
+	if w.bw == nil {
+		return nil
+	}
+	return w.bw.Flush()
+}
+
+// StatusWritten reports whether the handler has started the response.
+func (w *Writer) StatusWritten() bool {
+	return w.state != writerStateStatusLine
+}
+
+// Status is the final status code written, or "" if none has been.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// Hijack takes the connection away from the server. The caller becomes
+// responsible for closing it and must first consume the returned bytes,
+// which were read from the connection but not part of the request.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.hijacked {
+		return nil, nil, ERROR_HIJACKED
+	}
+	if w.conn == nil {
+		return nil, nil, ERROR_NO_CONNECTION
+	}
+
+	// whatever the handler wrote before taking over must go out first
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	w.hijacked = true
+	buffered := w.buffered
+	w.buffered = nil
+	if w.onHijack != nil {
+		buffered = append(buffered, w.onHijack()...)
+	}
+	return w.conn, buffered, nil
+}
+
+// OnHijack registers fn to run when the connection is hijacked. Whatever
+// fn returns is appended to the buffered bytes handed to the caller, which
+// lets the server stop its own reads from the connection first.
+func (w *Writer) OnHijack(fn func() []byte) {
+	w.onHijack = fn
+}
+
+// OnWriteStatus registers fn to run once the final status line has been
+// written, e.g. to time the response.
+func (w *Writer) OnWriteStatus(fn func(StatusCode)) {
+	w.onStatus = append(w.onStatus, fn)
+}
+
+// OnWriteHeaders registers fn to run once the header block has been
+// written.
+func (w *Writer) OnWriteHeaders(fn func()) {
+	w.onHeader = append(w.onHeader, fn)
+}
+
+// Recording is a copy of a response taken as it is written.
+type Recording struct {
+	Status StatusCode
+	// Fields are the field lines in the order they were written.
+	Fields [][2]string
+	// Body is the body as sent, chunk framing included.
+	Body []byte
+	// Truncated is set, and Body dropped, once the body outgrows the
+	// limit passed to Record.
+	Truncated bool
+
+	limit int
+	// fields queued with AddHeader before Record was called, which belong
+	// to whoever added them rather than to the recorded response
+	queued int
+}
+
+func (r *Recording) add(p []byte) {
+	if r.Truncated {
+		return
+	}
+	if r.limit >= 0 && len(r.Body)+len(p) > r.limit {
+		r.Truncated = true
+		r.Body = nil
+		return
+	}
+	r.Body = append(r.Body, p...)
+}
+
+// Record makes the Writer keep a copy of the response from here on,
+// holding at most maxBody bytes of body; a negative maxBody keeps it all.
+// Fields already queued with AddHeader are left out.
+func (w *Writer) Record(maxBody int) *Recording {
+	w.record = &Recording{limit: maxBody, queued: len(w.extra)}
+	return w.record
+}
+
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
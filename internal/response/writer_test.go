@@ -0,0 +1,224 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeAndCollect(t *testing.T, write func(w *Writer)) string {
+	serverSide, client := net.Pipe()
+	go func() {
+		write(NewWriter(serverSide, nil))
+		serverSide.Close()
+	}()
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestWriterInformational(t *testing.T) {
+	out := writeAndCollect(t, func(w *Writer) {
+		hints := headers.NewHeaders()
+		hints.Add("link", "</style.css>; rel=preload; as=style")
+		assert.NoError(t, w.WriteInformational(StatusEarlyHints, hints))
+		assert.NoError(t, w.WriteInformational(StatusProcessing, nil))
+		assert.NoError(t, w.WriteStatusLine(StatusOk))
+		h := headers.NewHeaders()
+		h.Add("content-length", "0")
+		assert.NoError(t, w.WriteHeaders(h))
+		assert.ErrorIs(t, w.WriteInformational(StatusEarlyHints, nil), ERROR_WRITER_STATE)
+	})
+
+	assert.Equal(t,
+		"HTTP/1.1 103 Early Hints\r\nlink: </style.css>; rel=preload; as=style\r\n\r\n"+
+			"HTTP/1.1 102 Processing\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\ncontent-length: 0\r\n\r\n",
+		out,
+	)
+}
+
+func TestWriterStateMachine(t *testing.T) {
+	writeAndCollect(t, func(w *Writer) {
+		_, err := w.WriteBody([]byte("early"))
+		assert.ErrorIs(t, err, ERROR_WRITER_STATE)
+		assert.ErrorIs(t, w.WriteHeaders(headers.NewHeaders()), ERROR_WRITER_STATE)
+		assert.ErrorIs(t, w.WriteStatusLine(StatusContinue), ERROR_WRITER_STATE)
+		assert.ErrorIs(t, w.WriteInformational(StatusOk, nil), ERROR_NOT_INFORMATIONAL)
+		assert.False(t, w.StatusWritten())
+
+		assert.NoError(t, w.WriteStatusLine(StatusSwitchingProtocols))
+		assert.ErrorIs(t, w.WriteStatusLine(StatusOk), ERROR_WRITER_STATE)
+		assert.True(t, w.StatusWritten())
+	})
+}
+
+func TestWriterAddHeader(t *testing.T) {
+	out := writeAndCollect(t, func(w *Writer) {
+		assert.NoError(t, w.AddHeader("Set-Cookie", "a=1"))
+		assert.NoError(t, w.WriteStatusLine(StatusOk))
+		assert.NoError(t, w.AddHeader("Set-Cookie", "b=2"))
+		assert.ErrorIs(t, w.AddHeader("x-evil", "1\r\ninjected: yes"), ERROR_INVALID_FIELD)
+		h := headers.NewHeaders()
+		h.Add("content-length", "0")
+		assert.NoError(t, w.WriteHeaders(h))
+		assert.ErrorIs(t, w.AddHeader("set-cookie", "c=3"), ERROR_WRITER_STATE)
+	})
+
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nset-cookie: a=1\r\nset-cookie: b=2\r\ncontent-length: 0\r\n\r\n", out)
+}
+
+func TestWriterHijack(t *testing.T) {
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	defer serverSide.Close()
+
+	w := NewWriter(serverSide, []byte("abc"))
+	w.OnHijack(func() []byte { return []byte("def") })
+
+	conn, buffered, err := w.Hijack()
+	require.NoError(t, err)
+	assert.Equal(t, serverSide, conn)
+	assert.Equal(t, "abcdef", string(buffered))
+	assert.True(t, w.Hijacked())
+
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ERROR_HIJACKED)
+	assert.ErrorIs(t, w.WriteStatusLine(StatusOk), ERROR_HIJACKED)
+}
+
+func TestWriterKeepAlive(t *testing.T) {
+	respond := func(w *Writer, h headers.Headers, body string) {
+		require.NoError(t, w.WriteStatusLine(StatusOk))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody([]byte(body))
+		require.NoError(t, err)
+	}
+
+	writeAndCollect(t, func(w *Writer) {
+		respond(w, GetDefaultHeaders(5), "hello")
+		assert.True(t, w.KeepAlive())
+	})
+	writeAndCollect(t, func(w *Writer) {
+		respond(w, GetDefaultHeaders(5), "hel")
+		assert.False(t, w.KeepAlive(), "short body")
+	})
+	writeAndCollect(t, func(w *Writer) {
+		respond(w, headers.NewHeaders(), "hello")
+		assert.False(t, w.KeepAlive(), "no framing")
+	})
+	writeAndCollect(t, func(w *Writer) {
+		h := GetDefaultHeaders(5)
+		h.Add("connection", "close")
+		respond(w, h, "hello")
+		assert.False(t, w.KeepAlive(), "connection: close")
+	})
+	writeAndCollect(t, func(w *Writer) {
+		h := headers.NewHeaders()
+		h.Add("transfer-encoding", "chunked")
+		respond(w, h, "")
+		assert.False(t, w.KeepAlive(), "unfinished chunked body")
+		require.NoError(t, w.WriteChunkedBodyDone())
+		assert.True(t, w.KeepAlive())
+	})
+}
+
+func TestBufferedWriter(t *testing.T) {
+	conn := &countingConn{}
+	w := NewBufferedWriter(conn, bufio.NewWriter(conn))
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Zero(t, conn.writes)
+
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 1, conn.writes)
+	assert.Contains(t, conn.out.String(), "\r\n\r\nhello")
+}
+
+func TestWriterRecord(t *testing.T) {
+	var out bytes.Buffer
+	w := NewDetachedWriter(&out)
+	// queued by someone else before recording starts
+	require.NoError(t, w.AddHeader("vary", "Origin"))
+	rec := w.Record(8)
+	headersWritten := false
+	w.OnWriteHeaders(func() { headersWritten = true })
+
+	require.NoError(t, w.AddHeader("set-cookie", "a=1"))
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	assert.True(t, headersWritten)
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusOk, rec.Status)
+	assert.Equal(t, [2]string{"set-cookie", "a=1"}, rec.Fields[0])
+	assert.ElementsMatch(t, [][2]string{{"content-length", "5"}, {"content-type", "text/plain"}}, rec.Fields[1:])
+	assert.Equal(t, "hello", string(rec.Body))
+	assert.Contains(t, out.String(), "vary: Origin\r\n")
+	assert.Contains(t, out.String(), "\r\n\r\nhello")
+
+	_, err = w.WriteBody([]byte("more"))
+	require.NoError(t, err)
+	assert.True(t, rec.Truncated)
+	assert.Nil(t, rec.Body)
+
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, ERROR_NO_CONNECTION)
+}
+
+// countingConn records how many writes reach the connection, each of which
+// would be a syscall on a real socket.
+type countingConn struct {
+	net.Conn
+	writes int
+	out    bytes.Buffer
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.writes++
+	return c.out.Write(p)
+}
+
+func BenchmarkWriterSyscalls(b *testing.B) {
+	body := []byte(`{"status":"ok"}`)
+	respond := func(w *Writer) {
+		h := GetDefaultHeaders(len(body))
+		h.Add("content-type", "application/json")
+		h.Add("cache-control", "no-store")
+		h.Add("x-request-id", "4bf92f3577b34da6")
+		w.WriteStatusLine(StatusOk)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+		w.Flush()
+	}
+
+	b.Run("unbuffered", func(b *testing.B) {
+		conn := &countingConn{}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			conn.out.Reset()
+			respond(NewWriter(conn, nil))
+		}
+		b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		conn := &countingConn{}
+		bw := bufio.NewWriter(conn)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			conn.out.Reset()
+			respond(NewBufferedWriter(conn, bw))
+		}
+		b.ReportMetric(float64(conn.writes)/float64(b.N), "writes/op")
+	})
+}
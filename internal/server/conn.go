@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"sync"
+)
+
+const connBufferSize = 4096
+
+var readerPool = sync.Pool{
+	New: func() any { return bufio.NewReaderSize(nil, connBufferSize) },
+}
+
+var writerPool = sync.Pool{
+	New: func() any { return bufio.NewWriterSize(nil, connBufferSize) },
+}
+
+// connReader is what the request parser reads from: bytes already taken
+// off the connection but not consumed by the previous request (a pipelined
+// request, or what the disconnect watcher read) come before the bufio
+// reader.
+type connReader struct {
+	pending []byte
+	br      *bufio.Reader
+}
+
+func (c *connReader) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.br.Read(p)
+}
+
+// unread puts b back in front of whatever is still pending.
+func (c *connReader) unread(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	c.pending = append(b, c.pending...)
+}
+
+// drain returns everything read from the connection that nobody consumed.
+func (c *connReader) drain() []byte {
+	out := bytes.Clone(c.pending)
+	c.pending = nil
+	if n := c.br.Buffered(); n > 0 {
+		peeked, _ := c.br.Peek(n)
+		out = append(out, peeked...)
+		c.br.Discard(n)
+	}
+	return out
+}
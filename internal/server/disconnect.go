@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// maxWatchedBytes caps how much a client may send while its request is
+// being handled before we stop reading (and stop noticing disconnects).
+const maxWatchedBytes = 4096
+
+// disconnectWatcher reads from a connection in the background once the
+// request has been parsed, so that an EOF or reset from the client cancels
+// the request context.
+type disconnectWatcher struct {
+	conn   net.Conn
+	reader io.Reader
+	cancel context.CancelFunc
+	done   chan struct{}
+	read   []byte
+}
+
+// watchDisconnect reads from reader, which must be reading conn, until
+// stopped. The deadline that stops it is set on conn.
+func watchDisconnect(conn net.Conn, reader io.Reader, cancel context.CancelFunc) *disconnectWatcher {
+	d := &disconnectWatcher{
+		conn:   conn,
+		reader: reader,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *disconnectWatcher) run() {
+	defer close(d.done)
+
+	buf := make([]byte, 512)
+	for len(d.read) < maxWatchedBytes {
+		n, err := d.reader.Read(buf)
+		d.read = append(d.read, buf[:n]...)
+		if err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				d.cancel()
+			}
+			return
+		}
+	}
+}
+
+// stop ends the background read and returns whatever it consumed.
+func (d *disconnectWatcher) stop() []byte {
+	d.conn.SetReadDeadline(time.Unix(1, 0))
+	<-d.done
+	d.conn.SetReadDeadline(time.Time{})
+	return d.read
+}
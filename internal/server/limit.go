@@ -0,0 +1,108 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+// rejectTimeout bounds how long writing a 503 to a rejected client may take.
+const rejectTimeout = time.Second
+
+// WithMaxConnections caps how many connections are served at once. When
+// the cap is reached the server stops accepting until a connection closes,
+// leaving new clients in the kernel's listen backlog; with reject set it
+// instead answers them 503 and closes the connection.
+func WithMaxConnections(max int, reject bool) Option {
+	return func(s *Server) {
+		s.maxConns = max
+		s.rejectWhenFull = reject
+	}
+}
+
+// WithMaxConnectionsPerIP answers 503 to a client that already has max
+// connections open.
+func WithMaxConnectionsPerIP(max int) Option {
+	return func(s *Server) {
+		s.maxConnsPerIP = max
+	}
+}
+
+// admit takes a connection slot and a per-IP slot for conn, or rejects it.
+func (s *Server) admit(conn net.Conn) bool {
+	if s.connSlots != nil && s.rejectWhenFull {
+		select {
+		case s.connSlots <- struct{}{}:
+		default:
+			s.metrics.connRejected("max_connections")
+			go reject(conn)
+			return false
+		}
+	}
+
+	if ip := remoteIP(conn); s.maxConnsPerIP > 0 && ip != "" {
+		s.mu.Lock()
+		if s.connsPerIP == nil {
+			s.connsPerIP = map[string]int{}
+		}
+		full := s.connsPerIP[ip] >= s.maxConnsPerIP
+		if !full {
+			s.connsPerIP[ip]++
+		}
+		s.mu.Unlock()
+
+		if full {
+			if s.connSlots != nil {
+				<-s.connSlots
+			}
+			s.metrics.connRejected("max_connections_per_ip")
+			go reject(conn)
+			return false
+		}
+	}
+
+	return true
+}
+
+// release gives back the slots admit took.
+func (s *Server) release(conn net.Conn) {
+	if ip := remoteIP(conn); s.maxConnsPerIP > 0 && ip != "" {
+		s.mu.Lock()
+		if s.connsPerIP[ip]--; s.connsPerIP[ip] <= 0 {
+			delete(s.connsPerIP, ip)
+		}
+		s.mu.Unlock()
+	}
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+func reject(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(rejectTimeout))
+
+	h := headers.NewHeaders()
+	h.Add("content-length", "0")
+	h.Add("retry-after", "1")
+	h.Add("connection", "close")
+	if err := response.WriteStatusLine(conn, response.StatusServiceUnavailable); err != nil {
+		return
+	}
+	if err := response.WriteHeaders(conn, h); err != nil {
+		log.Println("write error:", err)
+	}
+}
+
+// remoteIP is the client's IP address, or "" for connections that have
+// none, such as over a Unix socket.
+func remoteIP(conn net.Conn) string {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	return addr.IP.String()
+}
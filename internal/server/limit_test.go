@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func dial(t *testing.T, s *Server) net.Conn {
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestMaxConnectionsReject(t *testing.T) {
+	s, err := ServeAddress("127.0.0.1:0", echoTarget, WithMaxConnections(1, true))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := dial(t, s)
+	// make sure the first connection holds the slot before dialing again
+	_, err = first.Write([]byte("GET /first HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	_, body := readResponse(t, bufio.NewReader(first))
+	assert.Equal(t, "/first:", body)
+
+	resp, _ := readResponse(t, bufio.NewReader(dial(t, s)))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	first.Close()
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET /again HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		return err == nil && resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+func TestMaxConnectionsBackpressure(t *testing.T) {
+	s, err := ServeAddress("127.0.0.1:0", echoTarget, WithMaxConnections(1, false))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := dial(t, s)
+	_, err = first.Write([]byte("GET /first HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, bufio.NewReader(first))
+
+	// the second client connects (the kernel accepts it) but is not served
+	second := dial(t, s)
+	_, err = second.Write([]byte("GET /second HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = second.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	first.Close()
+	second.SetReadDeadline(time.Time{})
+	_, body := readResponse(t, bufio.NewReader(second))
+	assert.Equal(t, "/second:", body)
+}
+
+func TestMaxConnectionsPerIP(t *testing.T) {
+	s, err := ServeAddress("127.0.0.1:0", echoTarget, WithMaxConnectionsPerIP(1))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := dial(t, s)
+	_, err = first.Write([]byte("GET /first HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, bufio.NewReader(first))
+
+	resp, _ := readResponse(t, bufio.NewReader(dial(t, s)))
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	first.Close()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.connsPerIP) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
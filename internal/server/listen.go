@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ERROR_NO_SYSTEMD_LISTENERS = fmt.Errorf("no sockets passed by systemd")
+var ERROR_SOCKET_IN_USE = fmt.Errorf("unix socket is in use")
+
+// listenFdsStart is the first descriptor systemd passes, after stdin,
+// stdout and stderr.
+const listenFdsStart = 3
+
+// Listen opens a listener for address, which is either a TCP address
+// ("127.0.0.1:8080", "[::1]:8080", ":8080", with port 0 picking a free
+// port) or a Unix socket path prefixed with "unix:".
+func Listen(address string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		return ListenUnix(path, 0)
+	}
+	return net.Listen("tcp", address)
+}
+
+// ListenUnix listens on a Unix domain socket at path, replacing a stale
+// socket file left by a previous run. A non-zero mode is applied to the
+// socket file, e.g. 0660 to let a reverse proxy in the same group connect.
+func ListenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		// a live server would still accept on it
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, ERROR_SOCKET_IN_USE
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+// SystemdListeners returns the sockets passed by systemd socket activation
+// (LISTEN_PID and LISTEN_FDS), in the order of the socket unit. The
+// variables are cleared so child processes do not pick them up.
+func SystemdListeners() ([]net.Listener, error) {
+	return listenFDs(listenFdsStart)
+}
+
+func listenFDs(start int) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, ERROR_NO_SYSTEMD_LISTENERS
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, ERROR_NO_SYSTEMD_LISTENERS
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := start; fd < start+count; fd++ {
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		// FileListener dups the descriptor, so ours can go either way
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
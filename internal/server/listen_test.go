@@ -0,0 +1,90 @@
+//go:build unix
+
+package server
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func get(t *testing.T, network, address string) string {
+	conn, err := net.Dial(network, address)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET /ping HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	_, body := readResponse(t, bufio.NewReader(conn))
+	return body
+}
+
+func TestServeAddress(t *testing.T) {
+	s, err := ServeAddress("127.0.0.1:0", echoTarget)
+	require.NoError(t, err)
+	defer s.Close()
+
+	addr := s.Addr().(*net.TCPAddr)
+	assert.True(t, addr.IP.IsLoopback())
+	assert.NotZero(t, addr.Port)
+	assert.Equal(t, "/ping:", get(t, "tcp", addr.String()))
+}
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http.sock")
+
+	// a stale socket file from a server that died is replaced
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := ListenUnix(path, 0600)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, err = ListenUnix(path, 0600)
+	assert.ErrorIs(t, err, ERROR_SOCKET_IN_USE)
+
+	s := ServeListener(listener, echoTarget)
+	assert.Equal(t, "/ping:", get(t, "unix", path))
+
+	require.NoError(t, s.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestSystemdListeners(t *testing.T) {
+	_, err := SystemdListeners()
+	assert.ErrorIs(t, err, ERROR_NO_SYSTEMD_LISTENERS)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	file, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	// listenFDs takes ownership of the descriptor it is given
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	file.Close()
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err := listenFDs(fd)
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	s := ServeListener(listeners[0], echoTarget)
+	defer s.Close()
+	assert.Equal(t, "/ping:", get(t, "tcp", tcp.Addr().String()))
+}
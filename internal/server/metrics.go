@@ -0,0 +1,181 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/metrics"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+// serverMetrics are what the server records about itself. A nil
+// *serverMetrics records nothing.
+type serverMetrics struct {
+	registry *metrics.Registry
+	path     string
+
+	activeConns   *metrics.Gauge
+	acceptedConns *metrics.Counter
+	rejectedConns *metrics.CounterVec
+	requests      *metrics.CounterVec
+	requestBytes  *metrics.Counter
+	responseBytes *metrics.Counter
+	parseErrors   *metrics.CounterVec
+	duration      *metrics.Histogram
+}
+
+// WithMetrics records connection and request metrics, and answers GET
+// requests for path with them in the Prometheus text format. With path ""
+// they are recorded but only reachable through Metrics.
+func WithMetrics(path string) Option {
+	return func(s *Server) {
+		r := metrics.NewRegistry()
+		s.metrics = &serverMetrics{
+			registry:      r,
+			path:          path,
+			activeConns:   r.Gauge("http_connections_active", "Connections currently open."),
+			acceptedConns: r.Counter("http_connections_accepted_total", "Connections accepted and served."),
+			rejectedConns: r.CounterVec("http_connections_rejected_total", "Connections turned away by a connection limit.", "reason"),
+			requests:      r.CounterVec("http_requests_total", "Requests answered, by method and status code.", "method", "status"),
+			requestBytes:  r.Counter("http_request_bytes_total", "Bytes read from client connections."),
+			responseBytes: r.Counter("http_response_bytes_total", "Bytes written to client connections."),
+			parseErrors:   r.CounterVec("http_parse_errors_total", "Requests that could not be parsed or were refused before the handler, by error.", "error"),
+			duration:      r.Histogram("http_request_duration_seconds", "Time from reading a request to its handler returning.", metrics.DefaultBuckets),
+		}
+	}
+}
+
+// Metrics is the registry the server records into, for adding
+// application metrics to the same endpoint. It is nil without WithMetrics.
+func (s *Server) Metrics() *metrics.Registry {
+	if s.metrics == nil {
+		return nil
+	}
+	return s.metrics.registry
+}
+
+func (m *serverMetrics) connOpened() {
+	if m == nil {
+		return
+	}
+	m.acceptedConns.Inc()
+	m.activeConns.Inc()
+}
+
+func (m *serverMetrics) connClosed() {
+	if m == nil {
+		return
+	}
+	m.activeConns.Dec()
+}
+
+func (m *serverMetrics) connRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.rejectedConns.With(reason).Inc()
+}
+
+func (m *serverMetrics) requestDone(method string, status response.StatusCode, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.requests.With(method, statusLabel(status)).Inc()
+	m.duration.Observe(time.Since(start).Seconds())
+}
+
+func (m *serverMetrics) parseFailed(err error) {
+	if m == nil {
+		return
+	}
+	m.parseErrors.With(errorLabel(err)).Inc()
+}
+
+// meter counts the bytes going through conn.
+func (m *serverMetrics) meter(conn net.Conn) net.Conn {
+	if m == nil {
+		return conn
+	}
+	return &meteredConn{Conn: conn, m: m}
+}
+
+// serves reports whether req is for the metrics endpoint.
+func (m *serverMetrics) serves(req *request.Request) bool {
+	if m == nil || m.path == "" || req.RequestLine.Method != "GET" {
+		return false
+	}
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	return path == m.path
+}
+
+func (m *serverMetrics) handle(w *response.Writer, req *request.Request) *HandlerError {
+	body := m.registry.Expose()
+	h := response.GetDefaultHeaders(len(body))
+	h.Add("content-type", metrics.ContentType)
+	if err := w.WriteStatusLine(response.StatusOk); err != nil {
+		return nil
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil
+	}
+	w.WriteBody(body)
+	return nil
+}
+
+type meteredConn struct {
+	net.Conn
+	m *serverMetrics
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.m.requestBytes.Add(uint64(n))
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.m.responseBytes.Add(uint64(n))
+	return n, err
+}
+
+// statusLabel is the numeric part of a status code, e.g. "404".
+func statusLabel(status response.StatusCode) string {
+	code, _, _ := strings.Cut(string(status), " ")
+	if _, err := strconv.Atoi(code); err != nil {
+		return "unknown"
+	}
+	return code
+}
+
+var bodyErrors = []error{
+	ERROR_EXPECTATION_FAILED,
+	ERROR_BODY_TOO_LARGE,
+	request.ERROR_INVALID_CONTENT_LENGTH,
+	request.ERROR_BAD_CHUNK_SIZE,
+	request.ERROR_BAD_CHUNK,
+	request.ERROR_UNEXPECTED_EOF,
+	request.ERROR_UNSUPPORTED_CONTENT_ENCODING,
+	request.ERROR_MALFORMED_ENCODED_BODY,
+	request.ERROR_DECODED_BODY_TOO_LARGE,
+}
+
+// errorLabel names err by the sentinel it wraps, so the label set stays
+// small whatever the client sends.
+func errorLabel(err error) string {
+	// the parser only ever reports its own and the headers sentinels
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		return parseErr.Err.Error()
+	}
+	for _, sentinel := range bodyErrors {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	s, err := ServeAddress("127.0.0.1:0", echoTarget, WithMetrics("/metrics"))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn := dial(t, s)
+	r := bufio.NewReader(conn)
+	_, err = conn.Write([]byte("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\none"))
+	require.NoError(t, err)
+	readResponse(t, r)
+
+	bad := dial(t, s)
+	_, err = bad.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\nContent-Length: nope\r\n\r\n"))
+	require.NoError(t, err)
+	resp, _ := readResponse(t, bufio.NewReader(bad))
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// the bad request closed its connection
+	assert.Eventually(t, func() bool {
+		return strings.Contains(string(s.Metrics().Expose()), "http_connections_active 1\n")
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = conn.Write([]byte("GET /metrics HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	resp, body := readResponse(t, r)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, metrics.ContentType, resp.Header.Get("Content-Type"))
+
+	assert.Contains(t, body, "http_connections_accepted_total 2\n")
+	assert.Contains(t, body, `http_requests_total{method="POST",status="200"} 1`+"\n")
+	assert.Contains(t, body, `http_requests_total{method="GET",status="400"} 1`+"\n")
+	assert.Contains(t, body, `http_parse_errors_total{error="invalid content-length"} 1`+"\n")
+	assert.Contains(t, body, "http_request_duration_seconds_count 2\n")
+	assert.NotContains(t, body, "http_request_bytes_total 0\n")
+}
+
+func TestMetricsRejectedConnections(t *testing.T) {
+	s, err := ServeAddress("127.0.0.1:0", echoTarget, WithMetrics(""), WithMaxConnections(1, true))
+	require.NoError(t, err)
+	defer s.Close()
+
+	first := dial(t, s)
+	_, err = first.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	readResponse(t, bufio.NewReader(first))
+	readResponse(t, bufio.NewReader(dial(t, s)))
+
+	assert.Contains(t, string(s.Metrics().Expose()), `http_connections_rejected_total{reason="max_connections"} 1`+"\n")
+}
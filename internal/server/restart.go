@@ -0,0 +1,146 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ERROR_LISTENER_NOT_INHERITABLE = fmt.Errorf("listener cannot be passed to a child process")
+var ERROR_NO_INHERITED_LISTENER = fmt.Errorf("no listener inherited from a parent process")
+var ERROR_CHILD_NOT_READY = fmt.Errorf("child process did not report ready")
+
+// The descriptors handed to a child started by StartChild are named by
+// these environment variables.
+const (
+	envListenerFD = "HTTP_TCP_LISTENER_FD"
+	envReadyFD    = "HTTP_TCP_READY_FD"
+)
+
+// StartChild starts cmd, normally a new copy of the running binary, with
+// the server's listening socket as an extra descriptor, and waits up to
+// timeout for it to call NotifyReady. The child picks the socket up with
+// InheritedListener. On success both processes accept on the socket until
+// the caller shuts this server down; on error the child has been killed.
+func (s *Server) StartChild(cmd *exec.Cmd, timeout time.Duration) error {
+	filer, ok := s.listener.(interface{ File() (*os.File, error) })
+	if !ok {
+		return ERROR_LISTENER_NOT_INHERITABLE
+	}
+	listenerFile, err := filer.File()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyR.Close()
+
+	// ExtraFiles[i] becomes descriptor 3+i in the child
+	fd := 3 + len(cmd.ExtraFiles)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, listenerFile, readyW)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(withoutInheritEnv(env),
+		envListenerFD+"="+strconv.Itoa(fd),
+		envReadyFD+"="+strconv.Itoa(fd+1),
+	)
+
+	err = cmd.Start()
+	// the child has its own copy; keeping ours would hide the child's exit
+	// from the read below
+	readyW.Close()
+	// passing the descriptor put the socket, which our listener shares, in
+	// blocking mode, and a blocked Accept could not be interrupted by Close
+	if rawConn, rerr := listenerFile.SyscallConn(); rerr == nil {
+		rawConn.Control(func(fd uintptr) {
+			syscall.SetNonblock(int(fd), true)
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyR.Read(make([]byte, 1))
+		ready <- err
+	}()
+
+	select {
+	case err = <-ready:
+	case <-time.After(timeout):
+		err = ERROR_CHILD_NOT_READY
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		return ERROR_CHILD_NOT_READY
+	}
+
+	// the socket file now belongs to the child as well
+	if unix, ok := s.listener.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+	}
+	return nil
+}
+
+func withoutInheritEnv(env []string) []string {
+	kept := make([]string, 0, len(env))
+	for _, kv := range env {
+		if strings.HasPrefix(kv, envListenerFD+"=") || strings.HasPrefix(kv, envReadyFD+"=") {
+			continue
+		}
+		kept = append(kept, kv)
+	}
+	return kept
+}
+
+// InheritedListener returns the listener passed by a parent's StartChild.
+func InheritedListener() (net.Listener, error) {
+	fd, err := strconv.Atoi(os.Getenv(envListenerFD))
+	if err != nil {
+		return nil, ERROR_NO_INHERITED_LISTENER
+	}
+	os.Unsetenv(envListenerFD)
+
+	file := os.NewFile(uintptr(fd), "inherited-listener")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// this process now owns the socket file, until it hands it on
+	if unix, ok := listener.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(true)
+	}
+	return listener, nil
+}
+
+// NotifyReady tells the parent that started this process with StartChild
+// that it is serving. It does nothing in a process started any other way.
+func NotifyReady() error {
+	fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+	if err != nil {
+		return nil
+	}
+	os.Unsetenv(envReadyFD)
+
+	file := os.NewFile(uintptr(fd), "ready")
+	defer file.Close()
+	_, err = file.Write([]byte{1})
+	return err
+}
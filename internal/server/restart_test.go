@@ -0,0 +1,63 @@
+//go:build unix
+
+package server
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func respondWith(body string) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+		return nil
+	}
+}
+
+// TestRestartChild is the child process started by TestStartChild.
+func TestRestartChild(t *testing.T) {
+	if os.Getenv("HTTP_TCP_TEST_CHILD") != "1" {
+		t.Skip("run by TestStartChild")
+	}
+
+	listener, err := InheritedListener()
+	require.NoError(t, err)
+	ServeListener(listener, respondWith("/ping:child"))
+	require.NoError(t, NotifyReady())
+
+	// the parent kills us when it is done
+	time.Sleep(time.Minute)
+}
+
+func TestStartChild(t *testing.T) {
+	parent, err := ServeAddress("127.0.0.1:0", echoTarget)
+	require.NoError(t, err)
+	addr := parent.Addr().String()
+	assert.Equal(t, "/ping:", get(t, "tcp", addr))
+
+	// a child that exits without reporting ready leaves the parent serving
+	failed := exec.Command(os.Args[0], "-test.run=^$")
+	assert.ErrorIs(t, parent.StartChild(failed, 10*time.Second), ERROR_CHILD_NOT_READY)
+	assert.Equal(t, "/ping:", get(t, "tcp", addr))
+
+	child := exec.Command(os.Args[0], "-test.run=^TestRestartChild$")
+	child.Env = append(os.Environ(), "HTTP_TCP_TEST_CHILD=1")
+	require.NoError(t, parent.StartChild(child, 10*time.Second))
+	t.Cleanup(func() {
+		child.Process.Kill()
+		child.Wait()
+	})
+
+	require.NoError(t, parent.Shutdown(context.Background()))
+	assert.Equal(t, "/ping:child", get(t, "tcp", addr))
+}
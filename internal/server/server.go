@@ -1,45 +1,147 @@
 package server
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/oliverTuesta/http-tcp/internal/request"
 	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/websocket"
 )
 
 type Server struct {
 	listener net.Listener
 	handler  Handler
 	closed   atomic.Bool
+
+	// connections being served, and whether each is in a request (true)
+	// or idle between requests (false)
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+
+	maxConns       int
+	rejectWhenFull bool
+	connSlots      chan struct{}
+	maxConnsPerIP  int
+	connsPerIP     map[string]int
+
+	maxDecodedBodySize int
+	maxBodySize        int
+	maxHeaderBytes     int
+	idleTimeout        time.Duration
+	readTimeout        time.Duration
+	pooledRequests     bool
+
+	webSocketHandler WebSocketHandler
+	webSocketConfig  websocket.Config
+
+	metrics *serverMetrics
 }
 
+var ERROR_EXPECTATION_FAILED = fmt.Errorf("unsupported expectation")
+var ERROR_BODY_TOO_LARGE = request.ERROR_BODY_TOO_LARGE
+
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
 }
 
-type Handler func(w io.Writer, req *request.Request) *HandlerError
+// StatusError is an error that knows the status to answer it with, which
+// lets NewHandlerError answer errors from packages built on this one.
+type StatusError interface {
+	error
+	StatusCode() response.StatusCode
+}
 
-func Serve(port int, handler Handler) (*Server, error) {
-	url := ":" + strconv.Itoa(port)
-	listener, err := net.Listen("tcp", url)
+type Handler func(w *response.Writer, req *request.Request) *HandlerError
+
+type Option func(*Server)
+
+// WithBodyDecompression transparently decodes gzip and deflate request
+// bodies before they reach the handler, up to maxSize decoded bytes.
+func WithBodyDecompression(maxSize int) Option {
+	return func(s *Server) {
+		s.maxDecodedBodySize = maxSize
+	}
+}
+
+// WithMaxBodySize answers 413 to requests declaring a Content-Length above
+// maxSize, before their body is read, and to chunked bodies once they grow
+// past it.
+func WithMaxBodySize(maxSize int) Option {
+	return func(s *Server) {
+		s.maxBodySize = maxSize
+	}
+}
+
+// WithPooledRequests parses requests into pooled buffers whose strings
+// alias the bytes read, and recycles them once the handler returns. The
+// handler, and any middleware, must not keep the request or anything taken
+// from it past returning; copy what has to outlive it.
+func WithPooledRequests() Option {
+	return func(s *Server) {
+		s.pooledRequests = true
+	}
+}
+
+// WithMaxHeaderBytes answers 431 to requests whose request line and header
+// fields take more than max bytes. The default is
+// request.DefaultMaxHeaderBytes.
+func WithMaxHeaderBytes(max int) Option {
+	return func(s *Server) {
+		s.maxHeaderBytes = max
+	}
+}
+
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	return ServeAddress(":"+strconv.Itoa(port), handler, opts...)
+}
+
+// ServeAddress listens on address, which Listen understands, and serves
+// it.
+func ServeAddress(address string, handler Handler, opts ...Option) (*Server, error) {
+	listener, err := Listen(address)
 	if err != nil {
 		return nil, err
 	}
+	return ServeListener(listener, handler, opts...), nil
+}
 
+// ServeListener serves connections accepted from listener until Close,
+// which also closes the listener.
+func ServeListener(listener net.Listener, handler Handler, opts ...Option) *Server {
 	server := &Server{
 		listener: listener,
 		handler:  handler,
 	}
+	for _, opt := range opts {
+		opt(server)
+	}
+	if server.maxConns > 0 {
+		server.connSlots = make(chan struct{}, server.maxConns)
+	}
 
 	go server.listen()
 
-	return server, nil
+	return server
+}
+
+// Addr is the address the server is listening on, e.g. the port picked
+// for ":0".
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
 func (s *Server) Close() error {
@@ -49,8 +151,17 @@ func (s *Server) Close() error {
 
 func (s *Server) listen() {
 	for {
+		// with a full server and no rejecting, stop accepting so new
+		// clients queue in the listen backlog
+		if s.connSlots != nil && !s.rejectWhenFull {
+			s.connSlots <- struct{}{}
+		}
+
 		conn, err := s.listener.Accept()
 		if err != nil {
+			if s.connSlots != nil && !s.rejectWhenFull {
+				<-s.connSlots
+			}
 			if s.closed.Load() {
 				return
 			}
@@ -58,20 +169,147 @@ func (s *Server) listen() {
 			continue
 		}
 
-		go s.handle(conn)
+		if !s.admit(conn) {
+			continue
+		}
+
+		s.metrics.connOpened()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.release(conn)
+			defer s.metrics.connClosed()
+			s.handle(conn)
+		}()
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(s.metrics.meter(conn))
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(s.metrics.meter(conn))
+	reader := &connReader{br: br}
 
-	defer conn.Close()
+	hijacked := false
+	defer func() {
+		s.untrack(conn)
+		br.Reset(nil)
+		readerPool.Put(br)
+		bw.Reset(nil)
+		writerPool.Put(bw)
+		// a hijacked connection belongs to the handler now
+		if !hijacked {
+			conn.Close()
+		}
+	}()
 
+	for {
+		// wait for the next request while idle, so that Shutdown can close
+		// the connection instead of waiting for it
+		if len(reader.pending) == 0 {
+			s.setActive(conn, false)
+			setReadTimeout(conn, s.idleTimeout, DefaultIdleTimeout)
+			if _, err := br.Peek(1); err != nil {
+				return
+			}
+		}
+		setReadTimeout(conn, s.readTimeout, DefaultReadTimeout)
+		if !s.setActive(conn, true) {
+			return
+		}
+
+		var keepAlive bool
+		keepAlive, hijacked = s.serveRequest(conn, reader, bw)
+		if hijacked {
+			return
+		}
+		if err := bw.Flush(); err != nil {
+			log.Println("write error:", err)
+			return
+		}
+		if !keepAlive || s.closed.Load() {
+			return
+		}
+	}
+}
+
+// serveRequest reads and answers one request from reader. It reports
+// whether the connection can carry another request, or that the handler
+// hijacked it.
+func (s *Server) serveRequest(conn net.Conn, reader *connReader, bw *bufio.Writer) (keepAlive bool, hijacked bool) {
 	fmt.Println("Request line 0:")
-	req, err := request.RequestFromReader(conn)
+	start := time.Now()
+	req, err := s.readRequest(reader)
 	fmt.Println("Request line 2:")
+	release := true
+	defer func() {
+		if req != nil && release {
+			req.Release()
+		}
+	}()
+	if err == nil {
+		err = s.checkExpectations(req)
+	}
 	if err != nil {
 		log.Println("error:", err)
-		return
+		s.refuse(bw, req, err, start)
+		return false, false
+	}
+
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.ReceivedAt = start
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	var watcher *disconnectWatcher
+	stopWatcher := func() []byte {
+		if watcher == nil {
+			return nil
+		}
+		read := watcher.stop()
+		watcher = nil
+		return read
+	}
+
+	w := response.NewBufferedWriter(conn, bw)
+	w.OnHijack(func() []byte {
+		read := stopWatcher()
+		conn.SetReadDeadline(time.Time{})
+		return append(append(req.Buffered(), read...), reader.drain()...)
+	})
+
+	// with "Expect: 100-continue" the client holds the body back until we
+	// ask for it, which we do only once the handler reads it
+	expectContinue := expectsContinue(req)
+	if expectContinue {
+		req.BeforeBodyRead(func() error {
+			if w.StatusWritten() {
+				return nil
+			}
+			// the client only starts sending now
+			setReadTimeout(conn, s.readTimeout, DefaultReadTimeout)
+			return w.WriteInformational(response.StatusContinue, nil)
+		})
+	}
+	req.AfterBodyRead(func() error {
+		if s.maxDecodedBodySize > 0 {
+			if err := req.DecodeBody(s.maxDecodedBodySize); err != nil {
+				return err
+			}
+		}
+		conn.SetReadDeadline(time.Time{})
+		watcher = watchDisconnect(conn, reader, cancel)
+		return nil
+	})
+
+	if !expectContinue {
+		if err := req.ReadBody(); err != nil {
+			log.Println("error:", err)
+			s.refuse(bw, req, err, start)
+			return false, false
+		}
 	}
 
 	// logs
@@ -88,25 +326,149 @@ func (s *Server) handle(conn net.Conn) {
 	fmt.Println(string(req.Body))
 	//
 
-	handlerError := s.handler(conn, req)
-	if handlerError != nil {
-		WriteHandlerError(conn, handlerError)
-	} else {
-		response.WriteStatusLine(conn, response.StatusOk)
-		headers := response.GetDefaultHeaders(0)
-		err := response.WriteHeaders(conn, headers)
+	handler := s.handler
+	if s.metrics.serves(req) {
+		handler = s.metrics.handle
+	} else if s.webSocketHandler != nil && websocket.IsUpgradeRequest(req) {
+		handler = s.handleWebSocket
+	}
+	handlerError := handler(w, req)
+	defer func() { s.metrics.requestDone(req.RequestLine.Method, w.Status(), start) }()
+	if w.Hijacked() {
+		// the handler may go on using req along with the connection
+		release = false
+		return false, true
+	}
+
+	if !w.StatusWritten() {
+		statusCode := response.StatusOk
+		if handlerError != nil {
+			statusCode = handlerError.StatusCode
+		}
+		err := w.WriteStatusLine(statusCode)
+		if err == nil {
+			err = w.WriteHeaders(response.GetDefaultHeaders(0))
+		}
 		if err != nil {
 			log.Println("write error:", err)
+			return false, false
 		}
 	}
 
+	// whatever the watcher read belongs to the next request; req shares
+	// its parser with the copies middleware made, so it knows what they read
+	reader.unread(append(req.Buffered(), stopWatcher()...))
+
+	return w.KeepAlive() && req.BodyRead() && ctx.Err() == nil && !wantsClose(req), false
+}
+
+// refuse answers a request that failed before reaching the handler. req
+// is nil if its headers could not be parsed.
+func (s *Server) refuse(w io.Writer, req *request.Request, err error, start time.Time) {
+	handlerError := NewHandlerError(err)
+	WriteHandlerError(w, handlerError)
+	s.metrics.parseFailed(err)
+	if req != nil {
+		s.metrics.requestDone(req.RequestLine.Method, handlerError.StatusCode, start)
+	}
+}
+
+func (s *Server) readRequest(reader io.Reader) (*request.Request, error) {
+	limits := request.Limits{MaxHeaderBytes: s.maxHeaderBytes, MaxBodySize: s.maxBodySize}
+	if s.pooledRequests {
+		return request.ReadHeadersPooled(reader, limits)
+	}
+	return request.ReadHeaders(reader, limits)
 }
 
+// checkExpectations rejects a request before any of its body is read.
+func (s *Server) checkExpectations(req *request.Request) error {
+	if expect, ok := req.Headers.Get("expect"); ok && !strings.EqualFold(strings.TrimSpace(expect), "100-continue") {
+		return ERROR_EXPECTATION_FAILED
+	}
+
+	if s.maxBodySize > 0 {
+		if contentLength, ok := req.Headers.Get("content-length"); ok {
+			n, err := strconv.Atoi(contentLength)
+			if err == nil && n > s.maxBodySize {
+				return ERROR_BODY_TOO_LARGE
+			}
+		}
+	}
+
+	return nil
+}
+
+func wantsClose(req *request.Request) bool {
+	connection, _ := req.Headers.Get("connection")
+	return strings.Contains(strings.ToLower(connection), "close")
+}
+
+func expectsContinue(req *request.Request) bool {
+	expect, ok := req.Headers.Get("expect")
+	return ok && strings.EqualFold(strings.TrimSpace(expect), "100-continue")
+}
+
+// NewHandlerError turns an error from parsing or reading a request into
+// the response the client should get.
+func NewHandlerError(err error) *HandlerError {
+	return &HandlerError{
+		StatusCode: statusFromError(err),
+		Message:    err.Error(),
+	}
+}
+
+// WriteHandlerError answers a request the connection cannot carry on
+// after, so it also tells the client the connection is closing.
 func WriteHandlerError(w io.Writer, handlerError *HandlerError) {
 	response.WriteStatusLine(w, handlerError.StatusCode)
 	headers := response.GetDefaultHeaders(0)
+	headers.Add("connection", "close")
 	err := response.WriteHeaders(w, headers)
 	if err != nil {
 		log.Println("write error:", err)
 	}
 }
+
+func statusFromError(err error) response.StatusCode {
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		return statusForParseError(parseErr)
+	}
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode()
+	}
+
+	switch {
+	case errors.Is(err, request.ERROR_DECODED_BODY_TOO_LARGE),
+		errors.Is(err, ERROR_BODY_TOO_LARGE):
+		return response.StatusContentTooLarge
+	case errors.Is(err, request.ERROR_UNSUPPORTED_CONTENT_ENCODING):
+		return response.StatusUnsupportedMedia
+	case errors.Is(err, ERROR_EXPECTATION_FAILED):
+		return response.StatusExpectationFailed
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.StatusRequestTimeout
+	default:
+		return response.StatusBadRequest
+	}
+}
+
+// statusForParseError turns the status a ParseError suggests into ours.
+func statusForParseError(parseErr *request.ParseError) response.StatusCode {
+	switch parseErr.Status {
+	case 413:
+		return response.StatusContentTooLarge
+	case 414:
+		return response.StatusURITooLong
+	case 431:
+		return response.StatusRequestHeaderFieldsTooLarge
+	case 501:
+		return response.StatusNotImplemented
+	case 505:
+		return response.StatusHTTPVersionNotSupported
+	default:
+		return response.StatusBadRequest
+	}
+}
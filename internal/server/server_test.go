@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveConn runs handler on one end of a pipe and returns the other.
+func serveConn(t *testing.T, handler Handler, opts ...Option) net.Conn {
+	serverSide, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	s := &Server{handler: handler}
+	for _, opt := range opts {
+		opt(s)
+	}
+	go s.handle(serverSide)
+	return client
+}
+
+func echoTarget(w *response.Writer, req *request.Request) *HandlerError {
+	body := []byte(req.RequestLine.RequestTarget + ":" + string(req.Body))
+	w.WriteStatusLine(response.StatusOk)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+	return nil
+}
+
+func readResponse(t *testing.T, r *bufio.Reader) (*http.Response, string) {
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	client := serveConn(t, echoTarget)
+	r := bufio.NewReader(client)
+
+	// the second request is pipelined behind the first
+	go client.Write([]byte(
+		"POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\none" +
+			"GET /b HTTP/1.1\r\nHost: x\r\n\r\n",
+	))
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/a:one", body)
+	_, body = readResponse(t, r)
+	assert.Equal(t, "/b:", body)
+
+	go client.Write([]byte("GET /c HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n"))
+	_, body = readResponse(t, r)
+	assert.Equal(t, "/c:", body)
+
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestKeepAliveClosesUnframedResponse(t *testing.T) {
+	client := serveConn(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(nil)
+		w.WriteBody([]byte("until close"))
+		return nil
+	})
+
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	out, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n\r\nuntil close", string(out))
+}
+
+func TestExpectContinue(t *testing.T) {
+	client := serveConn(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		// only the first read asks the client for the body
+		if err := req.ReadBody(); err != nil {
+			return NewHandlerError(err)
+		}
+		if err := req.ReadBody(); err != nil {
+			return NewHandlerError(err)
+		}
+		return echoTarget(w, req)
+	})
+	r := bufio.NewReader(client)
+
+	go client.Write([]byte("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nExpect: 100-continue\r\n\r\n"))
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\r\n", line)
+
+	go client.Write([]byte("one"))
+	resp, body := readResponse(t, r)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/a:one", body)
+}
+
+func TestExpectContinueEarlyBodyThroughCopy(t *testing.T) {
+	// middleware such as tracing hands the handler a WithContext copy, and
+	// the client sends its body without waiting for "100 Continue"
+	client := serveConn(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		req = req.WithContext(context.WithoutCancel(req.Context()))
+		if err := req.ReadBody(); err != nil {
+			return NewHandlerError(err)
+		}
+		return echoTarget(w, req)
+	})
+	r := bufio.NewReader(client)
+
+	go client.Write([]byte("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 26\r\nExpect: 100-continue\r\n\r\n" +
+		"GET /smuggled HTTP/1.1\r\n\r\n"))
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, http.StatusContinue, resp.StatusCode)
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/a:GET /smuggled HTTP/1.1\r\n\r\n", body)
+
+	// the body is not parsed again as a request of its own
+	go client.Write([]byte("GET /b HTTP/1.1\r\nHost: x\r\n\r\n"))
+	_, body = readResponse(t, r)
+	assert.Equal(t, "/b:", body)
+}
+
+func TestExpectUnsupported(t *testing.T) {
+	called := false
+	client := serveConn(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		called = true
+		return echoTarget(w, req)
+	})
+
+	go client.Write([]byte("POST /a HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\nExpect: something-else\r\n\r\n"))
+	resp, _ := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, http.StatusExpectationFailed, resp.StatusCode)
+	assert.True(t, resp.Close)
+	assert.False(t, called)
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	client := serveConn(t, echoTarget, WithMaxHeaderBytes(1024))
+
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\nX-Big: " + strings.Repeat("a", 2048) + "\r\n\r\n"))
+	resp, _ := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+}
+
+func TestMaxBodySizeChunked(t *testing.T) {
+	client := serveConn(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		if err := req.ReadBody(); err != nil {
+			return NewHandlerError(err)
+		}
+		return echoTarget(w, req)
+	}, WithMaxBodySize(8))
+
+	go client.Write([]byte("POST / HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n"))
+	resp, _ := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestTimeouts(t *testing.T) {
+	client := serveConn(t, echoTarget, WithIdleTimeout(50*time.Millisecond))
+	r := bufio.NewReader(client)
+	go client.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n\r\n"))
+	readResponse(t, r)
+
+	// an idle keep-alive connection is closed, not held open
+	_, err := r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// and so is one sending a request too slowly
+	client = serveConn(t, echoTarget, WithReadTimeout(50*time.Millisecond))
+	r = bufio.NewReader(client)
+	go client.Write([]byte("GET /a HTTP/1.1\r\nHost: x\r\n"))
+	resp, _ := readResponse(t, r)
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+	assert.True(t, resp.Close)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestPooledRequests(t *testing.T) {
+	var seen []headers.Headers
+	client := serveConn(t, func(w *response.Writer, req *request.Request) *HandlerError {
+		seen = append(seen, req.Headers)
+		if err := req.ReadBody(); err != nil {
+			return NewHandlerError(err)
+		}
+		return echoTarget(w, req)
+	}, WithPooledRequests())
+	r := bufio.NewReader(client)
+
+	go client.Write([]byte(
+		"POST /first HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\none" +
+			"GET /second HTTP/1.1\r\nHost: y\r\n\r\n",
+	))
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/first:one", body)
+	_, body = readResponse(t, r)
+	assert.Equal(t, "/second:", body)
+
+	// each request went back to the pool once its handler returned
+	require.Len(t, seen, 2)
+	assert.Empty(t, seen[0])
+	assert.Empty(t, seen[1])
+}
+
+func TestStatusFromParseError(t *testing.T) {
+	for raw, want := range map[string]response.StatusCode{
+		"GET / HTTP/1.1\r\nUser-Agent : curl\r\n\r\n":                                    response.StatusBadRequest,
+		"GET / HTTP/1.2\r\nHost: x\r\n\r\n":                                              response.StatusHTTPVersionNotSupported,
+		"BREW / HTTP/1.1\r\nHost: x\r\n\r\n":                                             response.StatusNotImplemented,
+		"POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n":                    response.StatusNotImplemented,
+		"POST / HTTP/1.1\r\nContent-Length: 99999999999\r\n\r\n":                         response.StatusContentTooLarge,
+		"GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("a", request.DefaultMaxHeaderBytes): response.StatusRequestHeaderFieldsTooLarge,
+		"GET /" + strings.Repeat("a", request.DefaultMaxHeaderBytes):                     response.StatusURITooLong,
+	} {
+		_, err := request.RequestFromReader(strings.NewReader(raw))
+		var parseErr *request.ParseError
+		require.ErrorAs(t, err, &parseErr, raw)
+		assert.Equal(t, want, statusFromError(err), parseErr.Err.Error())
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// shutdownPollInterval is how often Shutdown looks for connections that
+// have gone idle.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Shutdown stops accepting connections and waits for the ones in flight to
+// finish their current request. Idle keep-alive connections are closed
+// right away. If ctx ends first, the remaining connections are closed and
+// ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		s.closeConns(false)
+		select {
+		case <-done:
+			return err
+		case <-ctx.Done():
+			s.closeConns(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// setActive records whether conn is serving a request. It returns false if
+// the server is shutting down and conn should not start another one.
+func (s *Server) setActive(conn net.Conn, active bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if active && s.closed.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[net.Conn]bool{}
+	}
+	s.conns[conn] = active
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *Server) closeConns(includeActive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, active := range s.conns {
+		if !active || includeActive {
+			conn.Close()
+			delete(s.conns, conn)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := ServeAddress("127.0.0.1:0", func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		<-release
+		return echoTarget(w, req)
+	})
+	require.NoError(t, err)
+
+	busy, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer busy.Close()
+	idle, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+
+	_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// the idle connection is closed without waiting for the busy one
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	select {
+	case <-shutdown:
+		t.Fatal("Shutdown returned with a request in flight")
+	default:
+	}
+
+	// the busy one finishes its request, then is closed
+	close(release)
+	r := bufio.NewReader(busy)
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/slow:", body)
+	require.NoError(t, <-shutdown)
+	_, err = r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s, err := ServeAddress("127.0.0.1:0", func(w *response.Writer, req *request.Request) *HandlerError {
+		<-release
+		return nil
+	})
+	require.NoError(t, err)
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+
+	// give the request time to reach the handler
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+	out, _ := io.ReadAll(conn)
+	assert.Empty(t, out)
+}
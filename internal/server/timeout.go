@@ -0,0 +1,35 @@
+package server
+
+import (
+	"net"
+	"time"
+)
+
+const DefaultIdleTimeout = 2 * time.Minute
+const DefaultReadTimeout = 30 * time.Second
+
+// WithIdleTimeout closes keep-alive connections that send no request for
+// d. Zero or less means DefaultIdleTimeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithReadTimeout bounds how long a client may take to send a request,
+// body included, once it has started. Zero or less means
+// DefaultReadTimeout.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.readTimeout = d
+	}
+}
+
+// setReadTimeout makes reads from conn fail d from now, or after def if d
+// is unset.
+func setReadTimeout(conn net.Conn, d, def time.Duration) {
+	if d <= 0 {
+		d = def
+	}
+	conn.SetReadDeadline(time.Now().Add(d))
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"log"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/websocket"
+)
+
+type WebSocketHandler func(conn *websocket.Conn, req *request.Request)
+
+// WithWebSocket answers "Upgrade: websocket" requests with a 101 and hands
+// the connection to handler instead of the regular Handler. It is
+// websocket.Upgrade on a hijacked connection; call that from the Handler
+// instead to have middleware see the upgrade or to upgrade per route.
+func WithWebSocket(cfg websocket.Config, handler WebSocketHandler) Option {
+	return func(s *Server) {
+		s.webSocketConfig = cfg
+		s.webSocketHandler = handler
+	}
+}
+
+func (s *Server) handleWebSocket(w *response.Writer, req *request.Request) *HandlerError {
+	conn, err := websocket.Upgrade(w, req, s.webSocketConfig)
+	if err != nil {
+		log.Println("websocket handshake error:", err)
+		return &HandlerError{StatusCode: response.StatusBadRequest, Message: err.Error()}
+	}
+	s.webSocketHandler(conn, req)
+	return nil
+}
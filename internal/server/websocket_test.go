@@ -0,0 +1,39 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithWebSocket(t *testing.T) {
+	greet := func(conn *websocket.Conn, req *request.Request) {
+		conn.WriteMessage(websocket.TextMessage, []byte("hi "+req.RequestLine.RequestTarget))
+	}
+	client := serveConn(t, echoTarget, WithWebSocket(websocket.Config{}, greet))
+	r := bufio.NewReader(client)
+
+	// requests that are not upgrades still reach the handler
+	go client.Write([]byte("GET /plain HTTP/1.1\r\nHost: x\r\n\r\n"))
+	_, body := readResponse(t, r)
+	assert.Equal(t, "/plain:", body)
+
+	go client.Write([]byte("GET /chat HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	frame := make([]byte, 2+len("hi /chat"))
+	_, err = io.ReadFull(r, frame)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x81, byte(len("hi /chat"))}, frame[:2])
+	assert.Equal(t, "hi /chat", string(frame[2:]))
+}
@@ -0,0 +1,129 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+var ERROR_NO_KEYS = fmt.Errorf("at least one session key is required")
+var ERROR_BAD_KEY = fmt.Errorf("encryption keys must be 16, 24 or 32 bytes")
+var ERROR_WEAK_KEY = fmt.Errorf("signing keys must be at least 32 bytes")
+var ERROR_INVALID_COOKIE_VALUE = fmt.Errorf("session cookie failed verification")
+
+// codec seals payloads into cookie values with the first key and opens
+// them with any key, so keys can be rotated by putting a new one first
+// and dropping the oldest once its cookies have expired.
+type codec interface {
+	seal(name string, payload []byte) (string, error)
+	// open also reports whether a key other than the first was needed
+	open(name, value string) (payload []byte, stale bool, err error)
+}
+
+var encoding = base64.RawURLEncoding
+
+// signer appends an HMAC-SHA256 of the cookie name and payload; the
+// payload stays readable by the client.
+type signer struct {
+	keys [][]byte
+}
+
+// minSigningKeySize is the HMAC-SHA256 output size; shorter keys make the
+// signature easier to brute force than it needs to be.
+const minSigningKeySize = 32
+
+func newSigner(keys [][]byte) (signer, error) {
+	for _, key := range keys {
+		if len(key) < minSigningKeySize {
+			return signer{}, ERROR_WEAK_KEY
+		}
+	}
+	return signer{keys: keys}, nil
+}
+
+func (s signer) mac(key []byte, name, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name + "|" + payload))
+	return h.Sum(nil)
+}
+
+func (s signer) seal(name string, payload []byte) (string, error) {
+	encoded := encoding.EncodeToString(payload)
+	return encoded + "." + encoding.EncodeToString(s.mac(s.keys[0], name, encoded)), nil
+}
+
+func (s signer) open(name, value string) ([]byte, bool, error) {
+	encoded, sig, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, false, ERROR_INVALID_COOKIE_VALUE
+	}
+	mac, err := encoding.DecodeString(sig)
+	if err != nil {
+		return nil, false, ERROR_INVALID_COOKIE_VALUE
+	}
+
+	for i, key := range s.keys {
+		if hmac.Equal(mac, s.mac(key, name, encoded)) {
+			payload, err := encoding.DecodeString(encoded)
+			if err != nil {
+				return nil, false, ERROR_INVALID_COOKIE_VALUE
+			}
+			return payload, i > 0, nil
+		}
+	}
+	return nil, false, ERROR_INVALID_COOKIE_VALUE
+}
+
+// encrypter seals payloads with AES-GCM, authenticating the cookie name
+// as additional data, so the client can neither read nor alter them.
+type encrypter struct {
+	aeads []cipher.AEAD
+}
+
+func newEncrypter(keys [][]byte) (encrypter, error) {
+	var e encrypter
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return e, ERROR_BAD_KEY
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return e, err
+		}
+		e.aeads = append(e.aeads, aead)
+	}
+	return e, nil
+}
+
+func (e encrypter) seal(name string, payload []byte) (string, error) {
+	aead := e.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(aead.Seal(nonce, nonce, payload, []byte(name))), nil
+}
+
+func (e encrypter) open(name, value string) ([]byte, bool, error) {
+	sealed, err := encoding.DecodeString(value)
+	if err != nil {
+		return nil, false, ERROR_INVALID_COOKIE_VALUE
+	}
+
+	for i, aead := range e.aeads {
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		if payload, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return payload, i > 0, nil
+		}
+	}
+	return nil, false, ERROR_INVALID_COOKIE_VALUE
+}
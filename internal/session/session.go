@@ -0,0 +1,364 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/cookie"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+var ERROR_SESSION_TOO_LARGE = fmt.Errorf("session does not fit in a cookie")
+
+// maxCookieSize is the smallest limit browsers are required to support.
+const maxCookieSize = 4096
+
+const idBytes = 32
+
+type Config struct {
+	// CookieName defaults to "session".
+	CookieName string
+	// Cookie carries the Domain, Path, Secure, HttpOnly, SameSite and
+	// Partitioned attributes of the session cookie. Path defaults to "/"
+	// and HttpOnly is always set.
+	Cookie cookie.SetCookie
+
+	// Keys sign, or with Encrypt encrypt, the cookie. The first key is used
+	// for new cookies and every key is tried on incoming ones. Signing keys
+	// must be at least 32 random bytes; encryption keys must be AES-128,
+	// -192 or -256 sized.
+	Keys    [][]byte
+	Encrypt bool
+
+	// Store keeps session data on the server. Without one the data travels
+	// in the cookie itself, and such a session cannot be revoked: Destroy
+	// and Regenerate only replace the client's cookie, and a copy of the old
+	// one stays valid until IdleTimeout or AbsoluteTimeout expires it, or
+	// forever if neither is set. Use a Store, or rotate Keys, where
+	// sessions must be ended server-side (e.g. on logout or a password
+	// change).
+	Store Store
+
+	// IdleTimeout ends a session unused for that long; AbsoluteTimeout ends
+	// it that long after it started, however busy. Zero means no limit.
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+
+	// Now is the clock; tests swap it for a fake one.
+	Now func() time.Time
+}
+
+type Manager struct {
+	cfg   Config
+	codec codec
+}
+
+func New(cfg Config) (*Manager, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, ERROR_NO_KEYS
+	}
+	if cfg.CookieName == "" {
+		cfg.CookieName = "session"
+	}
+	if cfg.Cookie.Path == "" {
+		cfg.Cookie.Path = "/"
+	}
+	cfg.Cookie.HttpOnly = true
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	m := &Manager{cfg: cfg}
+	if cfg.Encrypt {
+		e, err := newEncrypter(cfg.Keys)
+		if err != nil {
+			return nil, err
+		}
+		m.codec = e
+	} else {
+		s, err := newSigner(cfg.Keys)
+		if err != nil {
+			return nil, err
+		}
+		m.codec = s
+	}
+	return m, nil
+}
+
+// Session is the state of one client across requests. Its methods are
+// safe to call from several goroutines.
+type Session struct {
+	mu          sync.Mutex
+	data        *Data
+	isNew       bool
+	dirty       bool
+	destroyed   bool
+	previousIDs []string
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.ID
+}
+
+// IsNew reports whether the session started with this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.data.Values[key]
+	return value, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Values == nil {
+		s.data.Values = map[string]string{}
+	}
+	s.data.Values[key] = value
+	s.dirty = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.Values, key)
+	s.dirty = true
+}
+
+// Regenerate gives the session a new id, keeping its values. Call it
+// whenever the client's privileges change, such as on login, so an id an
+// attacker planted beforehand is worthless afterwards.
+func (s *Session) Regenerate() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew {
+		s.previousIDs = append(s.previousIDs, s.data.ID)
+	}
+	s.data.ID = id
+	s.dirty = true
+	return nil
+}
+
+// Destroy ends the session, deleting its data and the client's cookie.
+// Without a Store, copies of the cookie stay valid; see Config.Store.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.dirty = true
+}
+
+type contextKey struct{}
+
+// FromRequest returns the session the middleware attached to req, or nil
+// outside of it.
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+// Handler attaches a session to every request. Changes are saved, and the
+// cookie set, when the status line is written, so a handler must make them
+// before starting its response; with a Store, later changes still reach the
+// store once the handler returns.
+func (m *Manager) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		s, err := m.load(req)
+		if err != nil {
+			log.Println("session error:", err)
+			return &server.HandlerError{StatusCode: response.StatusInternalServerError}
+		}
+
+		committed := false
+		w.OnWriteStatus(func(response.StatusCode) {
+			committed = true
+			if err := m.commit(w, s, true); err != nil {
+				log.Println("session error:", err)
+			}
+		})
+
+		handlerError := next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+
+		if committed && m.cfg.Store != nil {
+			if err := m.commit(w, s, false); err != nil {
+				log.Println("session error:", err)
+			}
+		}
+		return handlerError
+	}
+}
+
+// load finds the request's session, or starts a new one if it has none or
+// its session has expired.
+func (m *Manager) load(req *request.Request) (*Session, error) {
+	now := m.cfg.Now()
+
+	if value, ok := cookie.Get(req, m.cfg.CookieName); ok {
+		data, stale, err := m.decode(value)
+		if err == nil && data != nil {
+			if m.expired(data, now) {
+				if m.cfg.Store != nil {
+					m.cfg.Store.Delete(data.ID)
+				}
+			} else {
+				// touching LastSeen slides the idle timeout, and a cookie
+				// sealed with an old key is sealed again with the new one
+				data.LastSeen = now
+				return &Session{data: data, dirty: stale || m.cfg.IdleTimeout > 0}, nil
+			}
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{data: &Data{ID: id, Created: now, LastSeen: now}, isNew: true}, nil
+}
+
+// decode opens a cookie value. With a Store the cookie holds only an id;
+// a missing record decodes to nil data rather than adopting the id.
+func (m *Manager) decode(value string) (*Data, bool, error) {
+	payload, stale, err := m.codec.open(m.cfg.CookieName, value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if m.cfg.Store == nil {
+		var data Data
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return nil, false, err
+		}
+		return &data, stale, nil
+	}
+
+	data, ok, err := m.cfg.Store.Load(string(payload))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return data, stale, nil
+}
+
+func (m *Manager) expired(data *Data, now time.Time) bool {
+	if m.cfg.AbsoluteTimeout > 0 && now.Sub(data.Created) > m.cfg.AbsoluteTimeout {
+		return true
+	}
+	return m.cfg.IdleTimeout > 0 && now.Sub(data.LastSeen) > m.cfg.IdleTimeout
+}
+
+// expiry is when the session ends if it is not used again.
+func (m *Manager) expiry(data *Data) time.Time {
+	var expires time.Time
+	if m.cfg.IdleTimeout > 0 {
+		expires = data.LastSeen.Add(m.cfg.IdleTimeout)
+	}
+	if m.cfg.AbsoluteTimeout > 0 {
+		absolute := data.Created.Add(m.cfg.AbsoluteTimeout)
+		if expires.IsZero() || absolute.Before(expires) {
+			expires = absolute
+		}
+	}
+	return expires
+}
+
+// commit saves a changed session and, while the headers are still open,
+// sends the cookie. A new session nobody wrote to is not worth a cookie.
+func (m *Manager) commit(w *response.Writer, s *Session, sendCookie bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty || (s.isNew && len(s.data.Values) == 0 && !s.destroyed) {
+		return nil
+	}
+	s.dirty = false
+
+	if m.cfg.Store != nil {
+		for _, id := range s.previousIDs {
+			if err := m.cfg.Store.Delete(id); err != nil {
+				return err
+			}
+		}
+		s.previousIDs = nil
+	}
+
+	c := m.cfg.Cookie
+	c.Name = m.cfg.CookieName
+
+	if s.destroyed {
+		if m.cfg.Store != nil && !s.isNew {
+			if err := m.cfg.Store.Delete(s.data.ID); err != nil {
+				return err
+			}
+		}
+		if !sendCookie {
+			return nil
+		}
+		c.MaxAge = -1
+		return cookie.Set(w, c)
+	}
+
+	expires := m.expiry(s.data)
+	var payload []byte
+	if m.cfg.Store != nil {
+		if err := m.cfg.Store.Save(s.data, expires); err != nil {
+			return err
+		}
+		payload = []byte(s.data.ID)
+	} else {
+		var err error
+		if payload, err = json.Marshal(s.data); err != nil {
+			return err
+		}
+	}
+	if !sendCookie {
+		return nil
+	}
+
+	value, err := m.codec.seal(c.Name, payload)
+	if err != nil {
+		return err
+	}
+	if len(c.Name)+len(value) > maxCookieSize {
+		return ERROR_SESSION_TOO_LARGE
+	}
+	c.Value = value
+	if !expires.IsZero() {
+		c.MaxAge = max(1, int(expires.Sub(m.cfg.Now()).Seconds()))
+	}
+	return cookie.Set(w, c)
+}
+
+func newID() (string, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validID(id string) bool {
+	if len(id) != 2*idBytes {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,239 @@
+package session
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var key1 = []byte("0123456789abcdef0123456789abcdef")
+var key2 = []byte("fedcba9876543210fedcba9876543210")
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// browser replays the session cookie across requests.
+type browser struct {
+	t      *testing.T
+	m      *Manager
+	cookie string
+}
+
+// do runs fn on the session of one request and returns the Set-Cookie
+// line of the response, if any.
+func (b *browser) do(fn func(s *Session)) string {
+	raw := "GET / HTTP/1.1\r\nHost: x\r\n"
+	if b.cookie != "" {
+		raw += "Cookie: " + b.cookie + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(b.t, err)
+
+	handler := b.m.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		fn(FromRequest(req))
+		return nil
+	})
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer serverSide.Close()
+		w := response.NewWriter(serverSide, nil)
+		if handler(w, req) == nil && !w.StatusWritten() {
+			w.WriteStatusLine(response.StatusOk)
+			w.WriteHeaders(response.GetDefaultHeaders(0))
+		}
+	}()
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(b.t, err)
+	io.Copy(io.Discard, resp.Body)
+
+	setCookie := resp.Header.Get("Set-Cookie")
+	if setCookie != "" {
+		b.cookie, _, _ = strings.Cut(setCookie, ";")
+		if strings.Contains(setCookie, "Max-Age=0") {
+			b.cookie = ""
+		}
+	}
+	return setCookie
+}
+
+func newManager(t *testing.T, cfg Config) *Manager {
+	m, err := New(cfg)
+	require.NoError(t, err)
+	return m
+}
+
+func TestCookieSession(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		b := &browser{t: t, m: newManager(t, Config{Keys: [][]byte{key1}, Encrypt: encrypt})}
+
+		// an untouched new session sets no cookie
+		assert.Empty(t, b.do(func(s *Session) { assert.True(t, s.IsNew()) }))
+
+		setCookie := b.do(func(s *Session) { s.Set("user", "ada") })
+		assert.Contains(t, setCookie, "; Path=/; HttpOnly")
+		b.do(func(s *Session) {
+			assert.False(t, s.IsNew())
+			user, _ := s.Get("user")
+			assert.Equal(t, "ada", user)
+		})
+
+		_, value, _ := strings.Cut(b.cookie, "=")
+		payload, _, _ := strings.Cut(value, ".")
+		decoded, _ := base64.RawURLEncoding.DecodeString(payload)
+		assert.Equal(t, !encrypt, strings.Contains(string(decoded), "ada"))
+
+		// a tampered cookie starts over
+		b.cookie = b.cookie[:len(b.cookie)-2] + "xx"
+		b.do(func(s *Session) {
+			assert.True(t, s.IsNew())
+			_, ok := s.Get("user")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		b := &browser{t: t, m: newManager(t, Config{Keys: [][]byte{key1}, Encrypt: encrypt})}
+		b.do(func(s *Session) { s.Set("user", "ada") })
+		oldCookie := b.cookie
+
+		// the new key goes first; cookies under the old one still work and
+		// come back sealed with the new one
+		b.m = newManager(t, Config{Keys: [][]byte{key2, key1}, Encrypt: encrypt})
+		assert.NotEmpty(t, b.do(func(s *Session) {
+			user, _ := s.Get("user")
+			assert.Equal(t, "ada", user)
+		}))
+		assert.NotEqual(t, oldCookie, b.cookie)
+
+		b.m = newManager(t, Config{Keys: [][]byte{key2}, Encrypt: encrypt})
+		b.do(func(s *Session) { assert.False(t, s.IsNew()) })
+
+		b.cookie = oldCookie
+		b.do(func(s *Session) { assert.True(t, s.IsNew()) })
+	}
+}
+
+func TestStoreSessionRegenerate(t *testing.T) {
+	store := NewMemoryStore()
+	b := &browser{t: t, m: newManager(t, Config{Keys: [][]byte{key1}, Store: store})}
+
+	var before, after string
+	b.do(func(s *Session) {
+		s.Set("cart", "3 items")
+		before = s.ID()
+	})
+	b.do(func(s *Session) {
+		require.NoError(t, s.Regenerate())
+		s.Set("user", "ada")
+		after = s.ID()
+	})
+	assert.NotEqual(t, before, after)
+
+	// the old id is gone from the store, so replaying it gets nothing
+	_, ok, err := store.Load(before)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	data, ok, err := store.Load(after)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"cart": "3 items", "user": "ada"}, data.Values)
+
+	// the cookie holds only the id
+	assert.NotContains(t, b.cookie, "ada")
+
+	assert.Contains(t, b.do(func(s *Session) { s.Destroy() }), "Max-Age=0")
+	_, ok, _ = store.Load(after)
+	assert.False(t, ok)
+}
+
+func TestStoreSavesLateChanges(t *testing.T) {
+	store := NewMemoryStore()
+	m := newManager(t, Config{Keys: [][]byte{key1}, Store: store})
+	b := &browser{t: t, m: m}
+	b.do(func(s *Session) { s.Set("a", "1") })
+
+	var id string
+	handler := m.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		s := FromRequest(req)
+		id = s.ID()
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+		s.Set("b", "2")
+		return nil
+	})
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\nCookie: " + b.cookie + "\r\n\r\n"))
+	require.NoError(t, err)
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	handler(response.NewWriter(serverSide, nil), req)
+	serverSide.Close()
+
+	data, ok, err := store.Load(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "2", data.Values["b"])
+}
+
+func TestExpiry(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	b := &browser{t: t, m: newManager(t, Config{
+		Keys:            [][]byte{key1},
+		IdleTimeout:     10 * time.Minute,
+		AbsoluteTimeout: time.Hour,
+		Now:             clock.Now,
+	})}
+
+	assert.Contains(t, b.do(func(s *Session) { s.Set("user", "ada") }), "Max-Age=600")
+
+	// each visit within the idle timeout slides it along
+	for i := 0; i < 5; i++ {
+		clock.Advance(9 * time.Minute)
+		b.do(func(s *Session) { assert.False(t, s.IsNew()) })
+	}
+
+	// the absolute timeout caps the cookie's lifetime, then ends the session
+	clock.Advance(9 * time.Minute)
+	assert.Contains(t, b.do(func(s *Session) { assert.False(t, s.IsNew()) }), "Max-Age=360")
+	clock.Advance(7 * time.Minute)
+	b.do(func(s *Session) { assert.True(t, s.IsNew()) })
+
+	b.do(func(s *Session) { s.Set("user", "ada") })
+	clock.Advance(11 * time.Minute)
+	b.do(func(s *Session) { assert.True(t, s.IsNew()) })
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	assert.ErrorIs(t, err, ERROR_NO_KEYS)
+	_, err = New(Config{Keys: [][]byte{[]byte("short")}, Encrypt: true})
+	assert.ErrorIs(t, err, ERROR_BAD_KEY)
+	_, err = New(Config{Keys: [][]byte{key1, []byte("0123456789abcdef")}})
+	assert.ErrorIs(t, err, ERROR_WEAK_KEY)
+}
@@ -0,0 +1,202 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ERROR_BAD_SESSION_ID = fmt.Errorf("malformed session id")
+
+// sweepEvery is how many saves pass between sweeps of expired sessions.
+const sweepEvery = 1024
+
+// Data is what a session holds between requests.
+type Data struct {
+	ID       string            `json:"id"`
+	Values   map[string]string `json:"values,omitempty"`
+	Created  time.Time         `json:"created"`
+	LastSeen time.Time         `json:"last_seen"`
+}
+
+// Store keeps session data on the server, so the cookie only carries the
+// session id. Load reports ok false for ids it does not know or whose
+// expiry has passed.
+type Store interface {
+	Load(id string) (data *Data, ok bool, err error)
+	Save(data *Data, expires time.Time) error
+	Delete(id string) error
+}
+
+type storedData struct {
+	Data    *Data     `json:"data"`
+	Expires time.Time `json:"expires"`
+}
+
+func (s storedData) expired(now time.Time) bool {
+	return !s.Expires.IsZero() && now.After(s.Expires)
+}
+
+// MemoryStore keeps sessions in memory, losing them on restart.
+type MemoryStore struct {
+	now func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]storedData
+	saves    int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, sessions: map[string]storedData{}}
+}
+
+func (s *MemoryStore) Load(id string) (*Data, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.sessions[id]
+	if !ok || stored.expired(s.now()) {
+		delete(s.sessions, id)
+		return nil, false, nil
+	}
+	return stored.Data.clone(), true, nil
+}
+
+func (s *MemoryStore) Save(data *Data, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[data.ID] = storedData{Data: data.clone(), Expires: expires}
+
+	s.saves++
+	if s.saves%sweepEvery == 0 {
+		now := s.now()
+		for id, stored := range s.sessions {
+			if stored.expired(now) {
+				delete(s.sessions, id)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// FileStore keeps each session as a JSON file in a directory, so sessions
+// survive restarts. Expired files are removed when loaded or by Sweep.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", ERROR_BAD_SESSION_ID
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileStore) Load(id string) (*Data, bool, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, false, err
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var stored storedData
+	if err := json.Unmarshal(raw, &stored); err != nil || stored.Data == nil {
+		os.Remove(path)
+		return nil, false, nil
+	}
+	if stored.expired(s.now()) {
+		os.Remove(path)
+		return nil, false, nil
+	}
+	return stored.Data, true, nil
+}
+
+// Save writes to a temporary file first so a crash never leaves a
+// half-written session behind.
+func (s *FileStore) Save(data *Data, expires time.Time) error {
+	path, err := s.path(data.ID)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(storedData{Data: data, Expires: expires})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Sweep removes every expired session file.
+func (s *FileStore) Sweep() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if id, ok := strings.CutSuffix(entry.Name(), ".json"); ok && validID(id) {
+			if _, _, err := s.Load(id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Data) clone() *Data {
+	c := *d
+	c.Values = make(map[string]string, len(d.Values))
+	for k, v := range d.Values {
+		c.Values[k] = v
+	}
+	return &c
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	id, err := newID()
+	require.NoError(t, err)
+	data := &Data{ID: id, Values: map[string]string{"user": "ada"}, Created: time.Unix(1000, 0).UTC()}
+	require.NoError(t, store.Save(data, time.Now().Add(time.Hour)))
+
+	// a second store on the same directory, as after a restart
+	reopened, err := NewFileStore(dir)
+	require.NoError(t, err)
+	loaded, ok, err := reopened.Load(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, data, loaded)
+
+	require.NoError(t, reopened.Delete(id))
+	_, ok, err = reopened.Load(id)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, _, err = store.Load("../../etc/passwd")
+	assert.ErrorIs(t, err, ERROR_BAD_SESSION_ID)
+}
+
+func TestFileStoreSweep(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	expired, _ := newID()
+	live, _ := newID()
+	require.NoError(t, store.Save(&Data{ID: expired}, time.Now().Add(-time.Minute)))
+	require.NoError(t, store.Save(&Data{ID: live}, time.Now().Add(time.Minute)))
+
+	require.NoError(t, store.Sweep())
+	_, err = os.Stat(filepath.Join(dir, expired+".json"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, live+".json"))
+	assert.NoError(t, err)
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	id, _ := newID()
+	require.NoError(t, store.Save(&Data{ID: id, Values: map[string]string{"a": "1"}}, time.Now().Add(-time.Second)))
+	_, ok, err := store.Load(id)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
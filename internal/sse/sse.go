@@ -0,0 +1,136 @@
+package sse
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+var ERROR_INVALID_EVENT_FIELD = fmt.Errorf("event field contains a line break")
+
+type Event struct {
+	ID    string
+	Event string
+	// Data may span several lines; each becomes its own "data:" field.
+	Data  string
+	Retry time.Duration
+}
+
+type Stream struct {
+	w           *response.Writer
+	lastEventID string
+}
+
+// NewStream starts a text/event-stream response on w.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	lastEventID, _ := req.Headers.Get("last-event-id")
+
+	h := headers.NewHeaders()
+	h.Add("content-type", "text/event-stream")
+	h.Add("cache-control", "no-cache")
+	h.Add("transfer-encoding", "chunked")
+	h.Add("connection", "close")
+
+	if err := w.WriteStatusLine(response.StatusOk); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	// let the client see the stream is open before the first event
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &Stream{w: w, lastEventID: lastEventID}, nil
+}
+
+// LastEventID is the id the client last saw, sent when it reconnects.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+func (s *Stream) Send(ev Event) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return ERROR_INVALID_EVENT_FIELD
+	}
+
+	var b strings.Builder
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	data := strings.ReplaceAll(ev.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore but which keeps
+// intermediaries from timing out an idle stream.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+func (s *Stream) write(payload string) error {
+	if _, err := s.w.WriteChunkedBody([]byte(payload)); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *Stream) Close() error {
+	return s.w.WriteChunkedBodyDone()
+}
+
+// Run sends events as they arrive and a heartbeat comment every heartbeat
+// interval, until events is closed or the client disconnects.
+func (s *Stream) Run(req *request.Request, events <-chan Event, heartbeat time.Duration) error {
+	ctx := req.Context()
+
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-events:
+			if !ok {
+				return s.Close()
+			}
+			if err := s.Send(ev); err != nil {
+				return err
+			}
+		case <-tick:
+			if err := s.Comment("heartbeat"); err != nil {
+				return err
+			}
+		}
+	}
+}
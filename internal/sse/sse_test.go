@@ -0,0 +1,99 @@
+package sse
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(t *testing.T, extra string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader("GET /events HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+// readChunk reads one chunk of a chunked body and returns its payload.
+func readChunk(t *testing.T, r *bufio.Reader) string {
+	sizeLine, err := r.ReadString('\n')
+	require.NoError(t, err)
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeLine), 16, 64)
+	require.NoError(t, err)
+	payload := make([]byte, size+2)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return string(payload[:size])
+}
+
+func startStream(t *testing.T, req *request.Request) (*Stream, *bufio.Reader) {
+	serverSide, client := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	reader := bufio.NewReader(client)
+
+	streamCh := make(chan *Stream, 1)
+	go func() {
+		stream, err := NewStream(response.NewWriter(serverSide, nil), req)
+		assert.NoError(t, err)
+		streamCh <- stream
+	}()
+
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	return <-streamCh, reader
+}
+
+func TestSend(t *testing.T) {
+	req := newRequest(t, "Last-Event-ID: 7\r\n")
+	stream, reader := startStream(t, req)
+	assert.Equal(t, "7", stream.LastEventID())
+
+	// net.Pipe blocks each write until it is read, so write from another
+	// goroutine and wait for it before the next
+	done := make(chan error)
+	go func() {
+		done <- stream.Send(Event{ID: "8", Event: "update", Data: "line one\nline two", Retry: 3 * time.Second})
+	}()
+	assert.Equal(t,
+		"event: update\nid: 8\nretry: 3000\ndata: line one\ndata: line two\n\n",
+		readChunk(t, reader),
+	)
+	require.NoError(t, <-done)
+
+	go func() { done <- stream.Comment("heartbeat") }()
+	assert.Equal(t, ": heartbeat\n\n", readChunk(t, reader))
+	require.NoError(t, <-done)
+
+	assert.ErrorIs(t, stream.Send(Event{ID: "bad\nid"}), ERROR_INVALID_EVENT_FIELD)
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req := newRequest(t, "").WithContext(ctx)
+	stream, reader := startStream(t, req)
+
+	events := make(chan Event)
+	result := make(chan error, 1)
+	go func() {
+		result <- stream.Run(req, events, 0)
+	}()
+
+	events <- Event{Data: "hello"}
+	assert.Equal(t, "data: hello\n\n", readChunk(t, reader))
+
+	cancel()
+	assert.ErrorIs(t, <-result, context.Canceled)
+}
@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+var ERROR_INVALID_TRACEPARENT = fmt.Errorf("invalid traceparent")
+var ERROR_INVALID_TRACESTATE = fmt.Errorf("invalid tracestate")
+
+const (
+	traceparentLen = 55
+	flagSampled    = 0x01
+	// tracestate may carry at most this many list members
+	maxTraceStateMembers = 32
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+func (id TraceID) IsZero() bool { return id == TraceID{} }
+func (id SpanID) IsZero() bool  { return id == SpanID{} }
+
+// TraceContext is what the W3C Trace Context headers carry: the trace, the
+// span that made the request, the trace flags and vendor state.
+type TraceContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string
+}
+
+func (tc TraceContext) Sampled() bool {
+	return tc.Flags&flagSampled != 0
+}
+
+// Traceparent formats tc as a version 00 traceparent value.
+func (tc TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", tc.TraceID, tc.SpanID, tc.Flags)
+}
+
+// ParseTraceparent parses a traceparent value. Versions above 00 are read
+// as far as the fields version 00 defines, as the spec asks.
+func ParseTraceparent(value string) (TraceContext, error) {
+	var tc TraceContext
+	if len(value) < traceparentLen {
+		return tc, ERROR_INVALID_TRACEPARENT
+	}
+
+	version, ok := parseHex(value[0:2])
+	if !ok || version[0] == 0xff || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return tc, ERROR_INVALID_TRACEPARENT
+	}
+	if version[0] == 0 && len(value) != traceparentLen {
+		return tc, ERROR_INVALID_TRACEPARENT
+	}
+	if len(value) > traceparentLen && value[traceparentLen] != '-' {
+		return tc, ERROR_INVALID_TRACEPARENT
+	}
+
+	traceID, ok1 := parseHex(value[3:35])
+	spanID, ok2 := parseHex(value[36:52])
+	flags, ok3 := parseHex(value[53:55])
+	if !ok1 || !ok2 || !ok3 {
+		return tc, ERROR_INVALID_TRACEPARENT
+	}
+	copy(tc.TraceID[:], traceID)
+	copy(tc.SpanID[:], spanID)
+	tc.Flags = flags[0]
+
+	if tc.TraceID.IsZero() || tc.SpanID.IsZero() {
+		return TraceContext{}, ERROR_INVALID_TRACEPARENT
+	}
+	return tc, nil
+}
+
+// parseHex decodes lowercase hex only; uppercase is invalid in
+// traceparent.
+func parseHex(s string) ([]byte, bool) {
+	for i := 0; i < len(s); i++ {
+		if !isDigit(s[i]) && (s[i] < 'a' || s[i] > 'f') {
+			return nil, false
+		}
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+// ValidateTraceState checks a tracestate value: at most 32 comma-separated
+// key=value members with distinct keys.
+func ValidateTraceState(value string) error {
+	seen := map[string]bool{}
+	for _, member := range strings.Split(value, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || !validTraceStateKey(key) || !validTraceStateValue(val) || seen[key] {
+			return ERROR_INVALID_TRACESTATE
+		}
+		seen[key] = true
+	}
+	if len(seen) > maxTraceStateMembers {
+		return ERROR_INVALID_TRACESTATE
+	}
+	return nil
+}
+
+// a key is a lowercase name, optionally "tenant@system" for multi-tenant
+// vendors
+func validTraceStateKey(key string) bool {
+	tenant, system, multiTenant := strings.Cut(key, "@")
+	if !multiTenant {
+		return len(key) <= 256 && validKeyPart(key, true)
+	}
+	return len(tenant) <= 241 && validKeyPart(tenant, false) &&
+		len(system) <= 14 && validKeyPart(system, true)
+}
+
+func validKeyPart(s string, alphaFirst bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+		case isDigit(c) && (i > 0 || !alphaFirst):
+		case i > 0 && (c == '_' || c == '-' || c == '*' || c == '/'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// a value is up to 256 printable ASCII characters other than ',' and '=',
+// not ending in a space
+func validTraceStateValue(val string) bool {
+	if val == "" || len(val) > 256 || val[len(val)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
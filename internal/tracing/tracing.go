@@ -0,0 +1,195 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+)
+
+// Span is one request as seen by this server.
+type Span struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	TraceState   string            `json:"trace_state,omitempty"`
+	Name         string            `json:"name"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Phases       []Phase           `json:"phases"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// Phase is a timed part of a span: "parse" until the handler starts,
+// "handler" until the status line is written and "write" until the
+// response is flushed.
+type Phase struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+type Config struct {
+	// Exporter receives every sampled span once its request is answered.
+	Exporter Exporter
+
+	// Now is the clock; tests swap it for a fake one.
+	Now func() time.Time
+}
+
+type Tracer struct {
+	cfg Config
+}
+
+func New(cfg Config) *Tracer {
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Tracer{cfg: cfg}
+}
+
+type contextKey struct{}
+
+// FromContext returns the trace context of the span handling a request,
+// which is what outgoing requests should carry.
+func FromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(contextKey{}).(TraceContext)
+	return tc, ok
+}
+
+func NewContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, tc)
+}
+
+// Inject sets the traceparent and tracestate fields of an outgoing
+// request's headers from ctx, replacing any copied from the incoming one.
+func Inject(ctx context.Context, h headers.Headers) {
+	tc, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	h.Add("traceparent", tc.Traceparent())
+	delete(h, "tracestate")
+	if tc.State != "" {
+		h.Add("tracestate", tc.State)
+	}
+}
+
+// Handler records a span for each request, continuing the trace named by
+// its traceparent field or starting a new one, and makes the span's trace
+// context available to next through the request context.
+func (t *Tracer) Handler(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		start := t.cfg.Now()
+		// spans outlive the request, whose strings may be recycled
+		target := strings.Clone(req.RequestLine.RequestTarget)
+		span := Span{
+			Name:  req.RequestLine.Method + " " + target,
+			Start: start,
+			Attributes: map[string]string{
+				"http.method": req.RequestLine.Method,
+				"http.target": target,
+			},
+		}
+		if req.RemoteAddr != "" {
+			span.Attributes["net.peer"] = req.RemoteAddr
+		}
+		if !req.ReceivedAt.IsZero() {
+			span.Start = req.ReceivedAt
+			span.Phases = append(span.Phases, Phase{Name: "parse", Start: req.ReceivedAt, End: start})
+		}
+
+		tc := continueTrace(req)
+		span.TraceID = tc.TraceID.String()
+		if !tc.SpanID.IsZero() {
+			span.ParentSpanID = tc.SpanID.String()
+		}
+		tc.SpanID = newSpanID()
+		span.SpanID = tc.SpanID.String()
+		span.TraceState = strings.Clone(tc.State)
+
+		var status response.StatusCode
+		var statusAt time.Time
+		w.OnWriteStatus(func(code response.StatusCode) {
+			status = code
+			statusAt = t.cfg.Now()
+		})
+
+		handlerError := next(w, req.WithContext(NewContext(req.Context(), tc)))
+
+		handlerEnd := statusAt
+		if handlerEnd.IsZero() {
+			handlerEnd = t.cfg.Now()
+		}
+		span.Phases = append(span.Phases, Phase{Name: "handler", Start: start, End: handlerEnd})
+
+		switch {
+		case w.Hijacked():
+			span.Attributes["http.hijacked"] = "true"
+		case !statusAt.IsZero():
+			// time the write by pushing the response out now
+			w.Flush()
+			span.Phases = append(span.Phases, Phase{Name: "write", Start: statusAt, End: t.cfg.Now()})
+		case handlerError != nil:
+			status = handlerError.StatusCode
+		default:
+			status = response.StatusOk
+		}
+		if status != "" {
+			span.Attributes["http.status"] = string(status)
+		}
+		span.End = span.Phases[len(span.Phases)-1].End
+
+		if tc.Sampled() && t.cfg.Exporter != nil {
+			if err := t.cfg.Exporter.Export(span); err != nil {
+				log.Println("trace export error:", err)
+			}
+		}
+		return handlerError
+	}
+}
+
+// continueTrace takes the trace from a valid traceparent field, with its
+// tracestate if that is valid too, or starts a new sampled trace with no
+// parent span.
+func continueTrace(req *request.Request) TraceContext {
+	parent, err := ParseTraceparent(headerValue(req, "traceparent"))
+	if err != nil {
+		return TraceContext{TraceID: newTraceID(), Flags: flagSampled}
+	}
+
+	parent.State = headerValue(req, "tracestate")
+	if ValidateTraceState(parent.State) != nil {
+		parent.State = ""
+	}
+	return parent
+}
+
+func headerValue(req *request.Request, name string) string {
+	value, _ := req.Headers.Get(name)
+	return value
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for id.IsZero() {
+		binary.BigEndian.PutUint64(id[:8], rand.Uint64())
+		binary.BigEndian.PutUint64(id[8:], rand.Uint64())
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for id.IsZero() {
+		binary.BigEndian.PutUint64(id[:], rand.Uint64())
+	}
+	return id
+}
@@ -0,0 +1,234 @@
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+	"github.com/oliverTuesta/http-tcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	tc, err := ParseTraceparent(parent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", tc.SpanID.String())
+	assert.True(t, tc.Sampled())
+	assert.Equal(t, parent, tc.Traceparent())
+
+	// a later version may append fields
+	_, err = ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.NoError(t, err)
+
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01x",
+	} {
+		_, err := ParseTraceparent(value)
+		assert.ErrorIs(t, err, ERROR_INVALID_TRACEPARENT, value)
+	}
+}
+
+func TestValidateTraceState(t *testing.T) {
+	assert.NoError(t, ValidateTraceState("rojo=00f067aa0ba902b7,congo=t61rcWkgMzE"))
+	assert.NoError(t, ValidateTraceState("tenant1@vendor=x , ,other=y"))
+	assert.NoError(t, ValidateTraceState(""))
+
+	tooMany := make([]string, 33)
+	for i := range tooMany {
+		tooMany[i] = "k" + strings.Repeat("a", i) + "=v"
+	}
+	for _, value := range []string{
+		"Rojo=1",
+		"rojo",
+		"rojo=1,rojo=2",
+		"rojo=a,b",
+		"rojo=a=b",
+		"1abc=x",
+		"@vendor=x",
+		strings.Join(tooMany, ","),
+	} {
+		assert.ErrorIs(t, ValidateTraceState(value), ERROR_INVALID_TRACESTATE, value)
+	}
+}
+
+// stepClock moves forward a millisecond every time it is read.
+type stepClock struct {
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(time.Millisecond)
+	return c.now
+}
+
+// serve runs handler on raw through a Writer on a pipe, discarding the
+// response.
+func serve(t *testing.T, handler server.Handler, raw string) *request.Request {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.ReceivedAt = time.Unix(1000, 0)
+
+	serverSide, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+
+	w := response.NewBufferedWriter(serverSide, bufio.NewWriter(serverSide))
+	handler(w, req)
+	serverSide.Close()
+	return req
+}
+
+func TestHandler(t *testing.T) {
+	exporter := NewMemoryExporter()
+	tracer := New(Config{Exporter: exporter, Now: (&stepClock{now: time.Unix(1000, 0)}).Now})
+
+	var seen TraceContext
+	handler := tracer.Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		seen, _ = FromContext(req.Context())
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(response.GetDefaultHeaders(2))
+		w.WriteBody([]byte("ok"))
+		return nil
+	})
+
+	serve(t, handler, "GET /a HTTP/1.1\r\nHost: x\r\nTraceparent: "+parent+"\r\nTracestate: rojo=1\r\n\r\n")
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentSpanID)
+	assert.Equal(t, seen.SpanID.String(), span.SpanID)
+	assert.NotEqual(t, span.ParentSpanID, span.SpanID)
+	assert.Equal(t, "rojo=1", span.TraceState)
+	assert.Equal(t, "GET /a", span.Name)
+	assert.Equal(t, "200 OK", span.Attributes["http.status"])
+
+	base := time.Unix(1000, 0)
+	assert.Equal(t, []Phase{
+		{Name: "parse", Start: base, End: base.Add(1 * time.Millisecond)},
+		{Name: "handler", Start: base.Add(1 * time.Millisecond), End: base.Add(2 * time.Millisecond)},
+		{Name: "write", Start: base.Add(2 * time.Millisecond), End: base.Add(3 * time.Millisecond)},
+	}, span.Phases)
+	assert.Equal(t, base, span.Start)
+	assert.Equal(t, base.Add(3*time.Millisecond), span.End)
+}
+
+func TestHandlerStartsTrace(t *testing.T) {
+	exporter := NewMemoryExporter()
+	handler := New(Config{Exporter: exporter}).Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{StatusCode: response.StatusNotFound}
+	})
+
+	// an invalid traceparent starts a new trace and drops the tracestate
+	serve(t, handler, "GET / HTTP/1.1\r\nHost: x\r\nTraceparent: junk\r\nTracestate: rojo=1\r\n\r\n")
+	spans := exporter.Spans()
+	require.Len(t, spans, 1)
+	assert.Len(t, spans[0].TraceID, 32)
+	assert.Empty(t, spans[0].ParentSpanID)
+	assert.Empty(t, spans[0].TraceState)
+	assert.Equal(t, "404 Not Found", spans[0].Attributes["http.status"])
+
+	// the caller decided not to sample this trace
+	exporter.Reset()
+	serve(t, handler, "GET / HTTP/1.1\r\nHost: x\r\nTraceparent: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00\r\n\r\n")
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestInject(t *testing.T) {
+	h := headers.NewHeaders()
+	h.Add("tracestate", "stale=1")
+	Inject(context.Background(), h)
+	assert.Equal(t, "stale=1", h["tracestate"])
+
+	tc, err := ParseTraceparent(parent)
+	require.NoError(t, err)
+	tc.State = "rojo=1"
+	Inject(NewContext(context.Background(), tc), h)
+	assert.Equal(t, parent, h["traceparent"])
+	assert.Equal(t, "rojo=1", h["tracestate"])
+}
+
+func TestJSONLExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exporter, err := NewJSONLExporter(path)
+	require.NoError(t, err)
+
+	require.NoError(t, exporter.Export(Span{TraceID: "a", SpanID: "1", Name: "GET /"}))
+	require.NoError(t, exporter.Export(Span{TraceID: "a", SpanID: "2", Name: "GET /b"}))
+	require.NoError(t, exporter.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	var span Span
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &span))
+	assert.Equal(t, "2", span.SpanID)
+	assert.Equal(t, "GET /b", span.Name)
+}
+
+// BenchmarkHandler parses a request and has a handler behind the tracing
+// middleware read its body through the copy the middleware hands it.
+func BenchmarkHandler(b *testing.B) {
+	raw := "POST /api/v1/items HTTP/1.1\r\n" +
+		"Host: api.example.com\r\n" +
+		"Content-Type: application/json\r\n" +
+		"Content-Length: 27\r\n" +
+		"Traceparent: " + parent + "\r\n" +
+		"\r\n" +
+		`{"name":"widget","qty":100}`
+	handler := New(Config{}).Handler(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if err := req.ReadBody(); err != nil {
+			return server.NewHandlerError(err)
+		}
+		return nil
+	})
+
+	for _, bench := range []struct {
+		name string
+		read func(io.Reader, request.Limits) (*request.Request, error)
+	}{
+		{"plain", request.ReadHeaders},
+		{"pooled", request.ReadHeadersPooled},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			reader := strings.NewReader(raw)
+			w := response.NewDetachedWriter(io.Discard)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				reader.Reset(raw)
+				req, err := bench.read(reader, request.Limits{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				handler(w, req)
+				req.Release()
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// permessage-deflate strips the empty stored block that ends a sync flush
+// (RFC 7692 section 7.2.1); it is added back before inflating.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// Both directions are negotiated without context takeover, so every message
+// is compressed and decompressed independently.
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+func inflate(data []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail)))
+	defer r.Close()
+
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, ERROR_MESSAGE_TOO_BIG
+	}
+
+	return out, nil
+}
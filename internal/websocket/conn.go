@@ -0,0 +1,349 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+type MessageType byte
+
+const (
+	continuationFrame MessageType = 0x0
+	TextMessage       MessageType = 0x1
+	BinaryMessage     MessageType = 0x2
+	CloseMessage      MessageType = 0x8
+	PingMessage       MessageType = 0x9
+	PongMessage       MessageType = 0xA
+)
+
+type CloseCode uint16
+
+const (
+	CloseNormal          CloseCode = 1000
+	CloseGoingAway       CloseCode = 1001
+	CloseProtocolError   CloseCode = 1002
+	CloseUnsupportedData CloseCode = 1003
+	CloseNoStatus        CloseCode = 1005
+	CloseAbnormal        CloseCode = 1006
+	CloseInvalidPayload  CloseCode = 1007
+	ClosePolicyViolation CloseCode = 1008
+	CloseMessageTooBig   CloseCode = 1009
+	CloseMandatoryExt    CloseCode = 1010
+	CloseInternalError   CloseCode = 1011
+)
+
+const maxControlPayloadBytes = 125
+
+var ERROR_PROTOCOL = fmt.Errorf("websocket protocol error")
+var ERROR_MESSAGE_TOO_BIG = fmt.Errorf("websocket message too big")
+var ERROR_INVALID_UTF8 = fmt.Errorf("websocket text message is not valid utf-8")
+var ERROR_CONNECTION_CLOSED = fmt.Errorf("websocket connection closed")
+
+// CloseError is returned by ReadMessage once the peer has sent a close frame.
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed: %d %s", e.Code, e.Reason)
+}
+
+type Conn struct {
+	conn           net.Conn
+	reader         *bufio.Reader
+	protocol       string
+	compress       bool
+	maxMessageSize int
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  MessageType
+	payload []byte
+}
+
+func newConn(conn net.Conn, buffered []byte, protocol string, compress bool, maxMessageSize int) *Conn {
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+	return &Conn{
+		conn:           conn,
+		reader:         bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), conn)),
+		protocol:       protocol,
+		compress:       compress,
+		maxMessageSize: maxMessageSize,
+	}
+}
+
+func (c *Conn) Subprotocol() string {
+	return c.protocol
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage returns the next complete data message, reassembling
+// fragments and answering pings along the way.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	compressed := false
+	fragmented := false
+
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch f.opcode {
+		case PingMessage:
+			if err := c.writeFrame(PongMessage, f.payload, false); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			continue
+		case CloseMessage:
+			return 0, nil, c.handleClose(f.payload)
+		case TextMessage, BinaryMessage:
+			if fragmented {
+				return 0, nil, c.fail(CloseProtocolError, ERROR_PROTOCOL)
+			}
+			messageType = f.opcode
+			compressed = f.rsv1
+		case continuationFrame:
+			// RSV1 marks a compressed message on its first frame only
+			// (RFC 7692 section 6)
+			if !fragmented || f.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, ERROR_PROTOCOL)
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, ERROR_PROTOCOL)
+		}
+
+		if len(message)+len(f.payload) > c.maxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, ERROR_MESSAGE_TOO_BIG)
+		}
+		message = append(message, f.payload...)
+		fragmented = true
+
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = inflate(message, c.maxMessageSize)
+			if errors.Is(err, ERROR_MESSAGE_TOO_BIG) {
+				return 0, nil, c.fail(CloseMessageTooBig, err)
+			}
+			if err != nil {
+				return 0, nil, c.fail(CloseInvalidPayload, err)
+			}
+		}
+
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, ERROR_INVALID_UTF8)
+		}
+
+		return messageType, message, nil
+	}
+}
+
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return ERROR_PROTOCOL
+	}
+
+	if c.compress {
+		compressed, err := deflate(data)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(messageType, compressed, true)
+	}
+
+	return c.writeFrame(messageType, data, false)
+}
+
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayloadBytes {
+		return ERROR_PROTOCOL
+	}
+	return c.writeFrame(PingMessage, data, false)
+}
+
+// Close sends a close frame and closes the underlying connection.
+func (c *Conn) Close(code CloseCode, reason string) error {
+	err := c.writeClose(code, reason)
+	closeErr := c.conn.Close()
+	if err != nil && !errors.Is(err, ERROR_CONNECTION_CLOSED) {
+		return err
+	}
+	return closeErr
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatus}
+
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, ERROR_PROTOCOL)
+	case len(payload) >= 2:
+		closeErr.Code = CloseCode(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, ERROR_PROTOCOL)
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(CloseInvalidPayload, ERROR_INVALID_UTF8)
+		}
+	}
+
+	echo := closeErr.Code
+	if echo == CloseNoStatus {
+		echo = CloseNormal
+	}
+	c.writeClose(echo, "")
+
+	return closeErr
+}
+
+func (c *Conn) fail(code CloseCode, err error) error {
+	c.writeClose(code, "")
+	return err
+}
+
+func (c *Conn) writeClose(code CloseCode, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayloadBytes {
+		payload = payload[:maxControlPayloadBytes]
+	}
+	return c.writeFrame(CloseMessage, payload, false)
+}
+
+func validCloseCode(code CloseCode) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code >= 1000 && code <= 1011:
+		return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+	}
+	return false
+}
+
+func (c *Conn) readFrame() (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return nil, err
+	}
+
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		rsv1:   head[0]&0x40 != 0,
+		opcode: MessageType(head[0] & 0x0F),
+	}
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	if head[0]&0x30 != 0 || (f.rsv1 && !c.compress) {
+		return nil, c.fail(CloseProtocolError, ERROR_PROTOCOL)
+	}
+	// clients must mask every frame they send
+	if !masked {
+		return nil, c.fail(CloseProtocolError, ERROR_PROTOCOL)
+	}
+
+	isControl := f.opcode&0x8 != 0
+	if isControl && (!f.fin || length > maxControlPayloadBytes || f.rsv1) {
+		return nil, c.fail(CloseProtocolError, ERROR_PROTOCOL)
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > uint64(c.maxMessageSize) {
+		return nil, c.fail(CloseMessageTooBig, ERROR_MESSAGE_TOO_BIG)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return nil, err
+	}
+
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+
+	return f, nil
+}
+
+func (c *Conn) writeFrame(opcode MessageType, payload []byte, rsv1 bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ERROR_CONNECTION_CLOSED
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	_, err := c.conn.Write(encodeFrame(opcode, payload, rsv1))
+	return err
+}
+
+// encodeFrame builds a single unmasked frame, as sent by a server.
+func encodeFrame(opcode MessageType, payload []byte, rsv1 bool) []byte {
+	b0 := 0x80 | byte(opcode)
+	if rsv1 {
+		b0 |= 0x40
+	}
+
+	buf := make([]byte, 0, 10+len(payload))
+	buf = append(buf, b0)
+
+	switch {
+	case len(payload) <= 125:
+		buf = append(buf, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		buf = append(buf, 126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, 127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+
+	return append(buf, payload...)
+}
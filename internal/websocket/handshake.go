@@ -0,0 +1,153 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/oliverTuesta/http-tcp/internal/headers"
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/oliverTuesta/http-tcp/internal/response"
+)
+
+var ERROR_NOT_WEBSOCKET_UPGRADE = fmt.Errorf("not a websocket upgrade request")
+var ERROR_BAD_WEBSOCKET_KEY = fmt.Errorf("bad sec-websocket-key")
+var ERROR_UNSUPPORTED_WEBSOCKET_VERSION = fmt.Errorf("unsupported websocket version")
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+type Config struct {
+	// Subprotocols the server speaks, in order of preference.
+	Protocols []string
+	// EnableCompression accepts permessage-deflate when the client offers it.
+	EnableCompression bool
+	// MaxMessageSize bounds a reassembled (and decompressed) message.
+	// Zero means DefaultMaxMessageSize.
+	MaxMessageSize int
+}
+
+const DefaultMaxMessageSize = 1 << 20
+
+func IsUpgradeRequest(req *request.Request) bool {
+	upgrade, _ := req.Headers.Get("upgrade")
+	connection, _ := req.Headers.Get("connection")
+	return hasToken(upgrade, "websocket") && hasToken(connection, "upgrade")
+}
+
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Handshake validates an opening handshake and returns the headers of the
+// 101 response along with the negotiated subprotocol and compression.
+func Handshake(req *request.Request, cfg Config) (headers.Headers, string, bool, error) {
+	if req.RequestLine.Method != "GET" || !IsUpgradeRequest(req) {
+		return nil, "", false, ERROR_NOT_WEBSOCKET_UPGRADE
+	}
+
+	version, _ := req.Headers.Get("sec-websocket-version")
+	if strings.TrimSpace(version) != "13" {
+		return nil, "", false, ERROR_UNSUPPORTED_WEBSOCKET_VERSION
+	}
+
+	key, _ := req.Headers.Get("sec-websocket-key")
+	key = strings.TrimSpace(key)
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return nil, "", false, ERROR_BAD_WEBSOCKET_KEY
+	}
+
+	h := headers.NewHeaders()
+	h.Add("upgrade", "websocket")
+	h.Add("connection", "Upgrade")
+	h.Add("sec-websocket-accept", AcceptKey(key))
+
+	offered, _ := req.Headers.Get("sec-websocket-protocol")
+	protocol := selectProtocol(offered, cfg.Protocols)
+	if protocol != "" {
+		h.Add("sec-websocket-protocol", protocol)
+	}
+
+	compress := false
+	if cfg.EnableCompression {
+		extensions, _ := req.Headers.Get("sec-websocket-extensions")
+		if acceptsDeflate(extensions) {
+			compress = true
+			h.Add("sec-websocket-extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+		}
+	}
+
+	return h, protocol, compress, nil
+}
+
+// Upgrade writes the 101 response and hijacks the connection from w. On a
+// handshake error nothing has been written and the caller still owns the
+// response.
+func Upgrade(w *response.Writer, req *request.Request, cfg Config) (*Conn, error) {
+	h, protocol, compress, err := Handshake(req, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	conn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(conn, buffered, protocol, compress, cfg.MaxMessageSize), nil
+}
+
+// selectProtocol picks the server's most preferred subprotocol among those
+// the client offered.
+func selectProtocol(offered string, supported []string) string {
+	offers := strings.Split(offered, ",")
+	for _, s := range supported {
+		for _, p := range offers {
+			if strings.TrimSpace(p) == s {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// acceptsDeflate reports whether any permessage-deflate offer can be served.
+// Offers restricting the server window are skipped since compress/flate
+// always uses the full 32KB window.
+func acceptsDeflate(extensions string) bool {
+	for _, offer := range strings.Split(extensions, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, param := range params[1:] {
+			name, _, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name == "server_max_window_bits" {
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
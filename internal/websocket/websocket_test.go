@@ -0,0 +1,264 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/oliverTuesta/http-tcp/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func upgradeRequest(t *testing.T, extra string) *request.Request {
+	raw := "GET /chat HTTP/1.1\r\n" +
+		"Host: server.example.com\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		extra +
+		"\r\n"
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func TestHandshake(t *testing.T) {
+	t.Run("RFC 6455 example key", func(t *testing.T) {
+		h, protocol, compress, err := Handshake(upgradeRequest(t, ""), Config{})
+		require.NoError(t, err)
+		assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", h["sec-websocket-accept"])
+		assert.Equal(t, "websocket", h["upgrade"])
+		assert.Empty(t, protocol)
+		assert.False(t, compress)
+	})
+
+	t.Run("Subprotocol negotiation", func(t *testing.T) {
+		req := upgradeRequest(t, "Sec-WebSocket-Protocol: chat, superchat\r\n")
+		h, protocol, _, err := Handshake(req, Config{Protocols: []string{"superchat"}})
+		require.NoError(t, err)
+		assert.Equal(t, "superchat", protocol)
+		assert.Equal(t, "superchat", h["sec-websocket-protocol"])
+
+		// the server's order of preference decides, not the client's
+		_, protocol, _, err = Handshake(upgradeRequest(t, "Sec-WebSocket-Protocol: chat, superchat\r\n"), Config{Protocols: []string{"superchat", "chat"}})
+		require.NoError(t, err)
+		assert.Equal(t, "superchat", protocol)
+	})
+
+	t.Run("permessage-deflate", func(t *testing.T) {
+		req := upgradeRequest(t, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+		h, _, compress, err := Handshake(req, Config{EnableCompression: true})
+		require.NoError(t, err)
+		assert.True(t, compress)
+		assert.Contains(t, h["sec-websocket-extensions"], "permessage-deflate")
+	})
+
+	t.Run("Bad version", func(t *testing.T) {
+		raw := "GET /chat HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n"
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		_, _, _, err = Handshake(req, Config{})
+		assert.ErrorIs(t, err, ERROR_UNSUPPORTED_WEBSOCKET_VERSION)
+	})
+
+	t.Run("Bad key", func(t *testing.T) {
+		raw := "GET /chat HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Key: short\r\nSec-WebSocket-Version: 13\r\n\r\n"
+		req, err := request.RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		_, _, _, err = Handshake(req, Config{})
+		assert.ErrorIs(t, err, ERROR_BAD_WEBSOCKET_KEY)
+	})
+}
+
+func clientFrame(fin bool, rsv1 bool, opcode MessageType, payload []byte) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	buf := []byte{b0}
+	switch {
+	case len(payload) <= 125:
+		buf = append(buf, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		buf = append(buf, 0x80|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(payload)))
+	default:
+		buf = append(buf, 0x80|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(len(payload)))
+	}
+	mask := []byte{0x37, 0xfa, 0x21, 0x3d}
+	buf = append(buf, mask...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	return buf
+}
+
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	head := make([]byte, 2)
+	_, err := io.ReadFull(r, head)
+	require.NoError(t, err)
+	require.Zero(t, head[1]&0x80, "server frames must not be masked")
+	length := int(head[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, err = io.ReadFull(r, ext)
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, err = io.ReadFull(r, ext)
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint64(ext))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	require.NoError(t, err)
+	return head[0], payload
+}
+
+func pipe(compress bool) (*Conn, net.Conn, *bufio.Reader) {
+	server, client := net.Pipe()
+	return newConn(server, nil, "", compress, 0), client, bufio.NewReader(client)
+}
+
+func TestFraming(t *testing.T) {
+	t.Run("Masked text message", func(t *testing.T) {
+		conn, client, _ := pipe(false)
+		go client.Write(clientFrame(true, false, TextMessage, []byte("Hello")))
+
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Equal(t, "Hello", string(data))
+	})
+
+	t.Run("Fragmented message with interleaved ping", func(t *testing.T) {
+		conn, client, clientReader := pipe(false)
+		go func() {
+			client.Write(clientFrame(false, false, TextMessage, []byte("Hel")))
+			client.Write(clientFrame(true, false, PingMessage, []byte("hi")))
+			client.Write(clientFrame(true, false, continuationFrame, []byte("lo")))
+		}()
+
+		pong := make(chan []byte, 1)
+		go func() {
+			head, payload := readServerFrame(t, clientReader)
+			assert.Equal(t, byte(0x80|PongMessage), head)
+			pong <- payload
+		}()
+
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Equal(t, "Hello", string(data))
+		assert.Equal(t, "hi", string(<-pong))
+	})
+
+	t.Run("Extended payload length", func(t *testing.T) {
+		conn, client, clientReader := pipe(false)
+		payload := []byte(strings.Repeat("x", 70000))
+		go func() {
+			head, data := readServerFrame(t, clientReader)
+			assert.Equal(t, byte(0x80|BinaryMessage), head)
+			assert.Equal(t, payload, data)
+			client.Close()
+		}()
+		require.NoError(t, conn.WriteMessage(BinaryMessage, payload))
+	})
+
+	t.Run("Unmasked frame is a protocol error", func(t *testing.T) {
+		conn, client, clientReader := pipe(false)
+		go client.Write([]byte{0x81, 0x02, 'h', 'i'})
+
+		closed := make(chan []byte, 1)
+		go func() {
+			_, payload := readServerFrame(t, clientReader)
+			closed <- payload
+		}()
+
+		_, _, err := conn.ReadMessage()
+		assert.ErrorIs(t, err, ERROR_PROTOCOL)
+		assert.Equal(t, uint16(CloseProtocolError), binary.BigEndian.Uint16(<-closed))
+	})
+
+	t.Run("RSV1 on a continuation frame is a protocol error", func(t *testing.T) {
+		conn, client, clientReader := pipe(true)
+		compressed, err := deflate([]byte("Hello Hello Hello"))
+		require.NoError(t, err)
+		go func() {
+			client.Write(clientFrame(false, true, TextMessage, compressed[:4]))
+			client.Write(clientFrame(true, true, continuationFrame, compressed[4:]))
+		}()
+
+		closed := make(chan []byte, 1)
+		go func() {
+			_, payload := readServerFrame(t, clientReader)
+			closed <- payload
+		}()
+
+		_, _, err = conn.ReadMessage()
+		assert.ErrorIs(t, err, ERROR_PROTOCOL)
+		assert.Equal(t, uint16(CloseProtocolError), binary.BigEndian.Uint16(<-closed))
+	})
+
+	t.Run("Close handshake echoes code", func(t *testing.T) {
+		conn, client, clientReader := pipe(false)
+		payload := binary.BigEndian.AppendUint16(nil, uint16(CloseGoingAway))
+		go client.Write(clientFrame(true, false, CloseMessage, append(payload, "bye"...)))
+
+		echoed := make(chan []byte, 1)
+		go func() {
+			_, payload := readServerFrame(t, clientReader)
+			echoed <- payload
+		}()
+
+		_, _, err := conn.ReadMessage()
+		var closeErr *CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, CloseGoingAway, closeErr.Code)
+		assert.Equal(t, "bye", closeErr.Reason)
+		assert.Equal(t, uint16(CloseGoingAway), binary.BigEndian.Uint16(<-echoed))
+	})
+
+	t.Run("Invalid UTF-8 text", func(t *testing.T) {
+		conn, client, clientReader := pipe(false)
+		go client.Write(clientFrame(true, false, TextMessage, []byte{0xff, 0xfe}))
+		go readServerFrame(t, clientReader)
+
+		_, _, err := conn.ReadMessage()
+		assert.ErrorIs(t, err, ERROR_INVALID_UTF8)
+	})
+
+	t.Run("permessage-deflate round trip", func(t *testing.T) {
+		conn, client, clientReader := pipe(true)
+		compressed, err := deflate([]byte("Hello Hello Hello"))
+		require.NoError(t, err)
+		go client.Write(clientFrame(true, true, TextMessage, compressed))
+
+		messageType, data, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, TextMessage, messageType)
+		assert.Equal(t, "Hello Hello Hello", string(data))
+
+		go func() {
+			require.NoError(t, conn.WriteMessage(TextMessage, data))
+		}()
+		head, payload := readServerFrame(t, clientReader)
+		assert.Equal(t, byte(0x80|0x40|TextMessage), head)
+		inflated, err := inflate(payload, 1024)
+		require.NoError(t, err)
+		assert.Equal(t, "Hello Hello Hello", string(inflated))
+	})
+}